@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Roles are granted through the "role" attribute of the caller X.509 certificate.
+// A partner certificate is bound to one partner through the "partnerKey" attribute
+// and a customer certificate to one customer through the "customerKey" attribute.
+const (
+	roleIssuer          = "issuer"
+	rolePartner         = "partner"
+	roleCustomerService = "customer-service"
+	roleAuditor         = "auditor"
+	roleAdmin           = "admin"
+	roleCustomer        = "customer"
+
+	roleAttribute        = "role"
+	partnerKeyAttribute  = "partnerKey"
+	customerKeyAttribute = "customerKey"
+	accessPolicyKey      = "config:accesspolicy"
+)
+
+// AccessPolicy binds every role to the MSPs it may be asserted from. A role without
+// an entry can not be used by anyone, whatever the certificate attributes say.
+type AccessPolicy struct {
+	RoleMSPs map[string][]string `json:"roleMSPs"`
+}
+
+type callerIdentity struct {
+	MSPID       string
+	Role        string
+	PartnerKey  string
+	CustomerKey string
+}
+
+var allRoles = []string{roleIssuer, rolePartner, roleCustomerService, roleAuditor, roleAdmin, roleCustomer}
+
+// Roles allowed to call each Invoke function. A function missing from this map can
+// not be called at all.
+var functionPermissions = map[string][]string{
+	"createcoupon":                {roleIssuer, roleAdmin},
+	"createsalestransaction":      {roleAdmin},
+	"querybykey":                  {roleIssuer, roleCustomerService, roleAuditor, roleAdmin},
+	"querybyrange":                {roleIssuer, roleCustomerService, roleAuditor, roleAdmin},
+	"validatecoupon":              {roleIssuer, rolePartner, roleCustomerService, roleAdmin},
+	"redeemcoupon":                {rolePartner, roleAdmin},
+	"deleterecord":                {roleAdmin},
+	"queryhistorybykey":           {roleCustomerService, roleAuditor, roleAdmin},
+	"querycouponsbycustomer":      {roleIssuer, roleCustomerService, roleAuditor, roleAdmin},
+	"querycustomercoupons":        {roleIssuer, roleCustomerService, roleAuditor, roleAdmin},
+	"setcurrencyconfig":           {roleAdmin},
+	"queryrecords":                {roleIssuer, roleCustomerService, roleAuditor, roleAdmin},
+	"createcustomer":              {roleCustomerService, roleAdmin},
+	"updatecustomer":              {roleCustomerService, roleAdmin},
+	"deactivatecustomer":          {roleCustomerService, roleAdmin},
+	"getcustomerbyemail":          {roleCustomerService, roleAdmin},
+	"registerpartner":             {roleAdmin},
+	"updatepartner":               {roleAdmin},
+	"suspendpartner":              {roleAdmin},
+	"createaddress":               {roleAdmin},
+	"updateaddress":               {roleAdmin},
+	"rebuildcouponindex":          {roleAdmin},
+	"setaccesspolicy":             {roleAdmin},
+	"transfercoupon":              {roleIssuer, roleCustomer},
+	"createcouponsbatch":          {roleIssuer, roleAdmin},
+	"createcampaign":              {roleIssuer, roleAdmin},
+	"createsettlementbatch":       {roleIssuer, roleAdmin},
+	"updatesettlementbatchstatus": {roleIssuer, rolePartner, roleAdmin},
+	"reverseredemption":           {roleIssuer, rolePartner, roleCustomerService, roleAdmin},
+	"createpartnercontract":       {roleAdmin},
+	"setemailindexsecret":         {roleAdmin},
+	"rebuildsettlementindex":      {roleAdmin},
+}
+
+//Function to read the role and bindings of the caller from the client certificate
+func getCallerIdentity(stub shim.ChaincodeStubInterface) (callerIdentity, error) {
+	var caller callerIdentity
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return caller, fmt.Errorf("Unable to read caller MSP ID error : %s", err.Error())
+	}
+	caller.MSPID = mspID
+	role, found, err := cid.GetAttributeValue(stub, roleAttribute)
+	if err != nil {
+		return caller, fmt.Errorf("Unable to read caller role error : %s", err.Error())
+	}
+	if found {
+		caller.Role = strings.ToLower(role)
+	}
+	partnerKey, found, err := cid.GetAttributeValue(stub, partnerKeyAttribute)
+	if err != nil {
+		return caller, fmt.Errorf("Unable to read caller partner key error : %s", err.Error())
+	}
+	if found {
+		caller.PartnerKey = strings.ToLower(partnerKey)
+	}
+	customerKey, found, err := cid.GetAttributeValue(stub, customerKeyAttribute)
+	if err != nil {
+		return caller, fmt.Errorf("Unable to read caller customer key error : %s", err.Error())
+	}
+	if found {
+		caller.CustomerKey = strings.ToLower(customerKey)
+	}
+	return caller, nil
+}
+
+//Function to check that the caller may invoke the function
+func checkPermission(stub shim.ChaincodeStubInterface, function string, caller callerIdentity) error {
+	allowedRoles, ok := functionPermissions[function]
+	if !ok {
+		return invalidArgumentError("function", "Invalid ChainCode Function : %s", function)
+	}
+	if !containsString(allowedRoles, caller.Role) {
+		return forbiddenError("", "Access denied : role %q may not call %s", caller.Role, function)
+	}
+	if caller.Role == rolePartner && caller.PartnerKey == "" {
+		return forbiddenError("", "Access denied : partner certificate is not bound to a partner key")
+	}
+	if caller.Role == roleCustomer && caller.CustomerKey == "" {
+		return forbiddenError("", "Access denied : customer certificate is not bound to a customer key")
+	}
+	accessPolicy, err := getAccessPolicy(stub)
+	if err != nil {
+		return err
+	}
+	if !containsString(accessPolicy.RoleMSPs[caller.Role], caller.MSPID) {
+		return forbiddenError("", "Access denied : role %s may not be used by MSP %s", caller.Role, caller.MSPID)
+	}
+	return nil
+}
+
+//Function to get the caller identity of the transaction
+func getCaller(stub shim.ChaincodeStubInterface) callerIdentity {
+	if transaction, ok := stub.(*txStub); ok {
+		return transaction.caller
+	}
+	return callerIdentity{}
+}
+
+//Function to get the access policy from the ledger
+func getAccessPolicy(stub shim.ChaincodeStubInterface) (AccessPolicy, error) {
+	var accessPolicy AccessPolicy
+	resultAsBytes, err := stub.GetState(accessPolicyKey)
+	if err != nil {
+		return accessPolicy, fmt.Errorf("Unable to fetch access policy error : %s", err.Error())
+	}
+	if resultAsBytes == nil {
+		return accessPolicy, nil
+	}
+	err = json.Unmarshal(resultAsBytes, &accessPolicy)
+	if err != nil {
+		return accessPolicy, fmt.Errorf("Invalid access policy error : %s", err.Error())
+	}
+	return accessPolicy, nil
+}
+
+//Function to replace the access policy
+func (c *CouponChaincode) SetAccessPolicy(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var accessPolicy AccessPolicy
+	err := parseArgs("setaccesspolicy", args, &accessPolicy)
+	if err != nil {
+		return errorResponse(err)
+	}
+	for role := range accessPolicy.RoleMSPs {
+		if !containsString(allRoles, role) {
+			return errorResponse(invalidArgumentError("roleMSPs", "Unknown role : %s", role))
+		}
+	}
+	if len(accessPolicy.RoleMSPs[roleAdmin]) == 0 {
+		return errorResponse(invalidArgumentError("roleMSPs", "Access policy must allow at least one MSP for the admin role"))
+	}
+	accessPolicyAsBytes, _ := json.Marshal(accessPolicy)
+	writeErr := stub.PutState(accessPolicyKey, accessPolicyAsBytes)
+	if writeErr != nil {
+		return errorResponse(fmt.Errorf("Access policy PutState failed : %s", writeErr.Error()))
+	}
+	return shim.Success(accessPolicyAsBytes)
+}
+
+//Function to bind every role to the MSP instantiating the chaincode until an admin
+//grants roles to other MSPs
+func (t *CouponChaincode) initAccessPolicy(stub shim.ChaincodeStubInterface) error {
+	accessPolicy, err := getAccessPolicy(stub)
+	if err != nil {
+		return err
+	}
+	if accessPolicy.RoleMSPs != nil {
+		return nil
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return fmt.Errorf("Unable to read instantiating MSP ID error : %s", err.Error())
+	}
+	accessPolicy.RoleMSPs = make(map[string][]string)
+	for _, role := range allRoles {
+		accessPolicy.RoleMSPs[role] = []string{mspID}
+	}
+	accessPolicyAsBytes, _ := json.Marshal(accessPolicy)
+	return stub.PutState(accessPolicyKey, accessPolicyAsBytes)
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+const addressKeyPrefix = "address"
+
+// Country specific address rules. Countries without an entry only require the
+// street, zip code and state to be present.
+type addressRules struct {
+	ZipCode *regexp.Regexp
+	States  map[string]string
+}
+
+var usStates = map[string]string{
+	"AL": "Alabama", "AK": "Alaska", "AZ": "Arizona", "AR": "Arkansas", "CA": "California",
+	"CO": "Colorado", "CT": "Connecticut", "DE": "Delaware", "DC": "District of Columbia",
+	"FL": "Florida", "GA": "Georgia", "HI": "Hawaii", "ID": "Idaho", "IL": "Illinois",
+	"IN": "Indiana", "IA": "Iowa", "KS": "Kansas", "KY": "Kentucky", "LA": "Louisiana",
+	"ME": "Maine", "MD": "Maryland", "MA": "Massachusetts", "MI": "Michigan", "MN": "Minnesota",
+	"MS": "Mississippi", "MO": "Missouri", "MT": "Montana", "NE": "Nebraska", "NV": "Nevada",
+	"NH": "New Hampshire", "NJ": "New Jersey", "NM": "New Mexico", "NY": "New York",
+	"NC": "North Carolina", "ND": "North Dakota", "OH": "Ohio", "OK": "Oklahoma", "OR": "Oregon",
+	"PA": "Pennsylvania", "RI": "Rhode Island", "SC": "South Carolina", "SD": "South Dakota",
+	"TN": "Tennessee", "TX": "Texas", "UT": "Utah", "VT": "Vermont", "VA": "Virginia",
+	"WA": "Washington", "WV": "West Virginia", "WI": "Wisconsin", "WY": "Wyoming",
+	"PR": "Puerto Rico",
+}
+
+var caProvinces = map[string]string{
+	"AB": "Alberta", "BC": "British Columbia", "MB": "Manitoba", "NB": "New Brunswick",
+	"NL": "Newfoundland and Labrador", "NS": "Nova Scotia", "NT": "Northwest Territories",
+	"NU": "Nunavut", "ON": "Ontario", "PE": "Prince Edward Island", "QC": "Quebec",
+	"SK": "Saskatchewan", "YT": "Yukon",
+}
+
+var countryAddressRules = map[string]addressRules{
+	"USA": {ZipCode: regexp.MustCompile(`^\d{5}(-\d{4})?$`), States: usStates},
+	"CAN": {ZipCode: regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`), States: caProvinces},
+	"GBR": {ZipCode: regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`)},
+	"IND": {ZipCode: regexp.MustCompile(`^[1-9]\d{5}$`)},
+}
+
+// Accepted spellings of the supported countries, addresses store the ISO alpha-3 code
+var countryAliases = map[string]string{
+	"US": "USA", "USA": "USA", "UNITED STATES": "USA", "UNITED STATES OF AMERICA": "USA",
+	"CA": "CAN", "CAN": "CAN", "CANADA": "CAN",
+	"GB": "GBR", "GBR": "GBR", "UK": "GBR", "UNITED KINGDOM": "GBR",
+	"IN": "IND", "IND": "IND", "INDIA": "IND",
+}
+
+//Function to create an address
+func (c *CouponChaincode) CreateAddress(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var address Address
+	err := parseArgs("createaddress", args, &address)
+	if err != nil {
+		return errorResponse(err)
+	}
+	address, err = normalizeAddress(address)
+	if err != nil {
+		return errorResponse(err)
+	}
+	address.Key = generateKey(stub, addressKeyPrefix)
+	addressAsBytes, _ := json.Marshal(address)
+	writeErr := stub.PutState(address.Key, addressAsBytes)
+	if writeErr != nil {
+		return errorResponse(fmt.Errorf("Address %s PutState failed: %s", address.Key, writeErr.Error()))
+	}
+	return shim.Success(addressAsBytes)
+}
+
+//Function to update an address
+func (c *CouponChaincode) UpdateAddress(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var address Address
+	err := parseArgs("updateaddress", args, &address)
+	if err != nil {
+		return errorResponse(err)
+	}
+	address.Key = strings.ToLower(address.Key)
+	_, found, err := getAddress(stub, address.Key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !found {
+		return errorResponse(notFoundError(address.Key, "Address %s does not exist", address.Key))
+	}
+	address, err = normalizeAddress(address)
+	if err != nil {
+		return errorResponse(err)
+	}
+	addressAsBytes, _ := json.Marshal(address)
+	writeErr := stub.PutState(address.Key, addressAsBytes)
+	if writeErr != nil {
+		return errorResponse(fmt.Errorf("Address %s PutState failed: %s", address.Key, writeErr.Error()))
+	}
+	return shim.Success(addressAsBytes)
+}
+
+//Function to get an address
+func getAddress(stub shim.ChaincodeStubInterface, addressKey string) (Address, bool, error) {
+	var address Address
+	addressKey = strings.ToLower(addressKey)
+	resultAsBytes, err := stub.GetState(addressKey)
+	if err != nil {
+		return address, false, fmt.Errorf("Unable to fetch address %s error : %s", addressKey, err.Error())
+	}
+	if resultAsBytes == nil {
+		return address, false, nil
+	}
+	err = json.Unmarshal(resultAsBytes, &address)
+	if err != nil {
+		return address, false, fmt.Errorf("Invalid address record %s error : %s", addressKey, err.Error())
+	}
+	address.Key = addressKey
+	return address, true, nil
+}
+
+//Function to validate an address with the rules of its country
+func normalizeAddress(address Address) (Address, error) {
+	address.Street = strings.TrimSpace(address.Street)
+	address.ZipCode = strings.ToUpper(strings.TrimSpace(address.ZipCode))
+	address.State = strings.TrimSpace(address.State)
+	country := normalizeCountry(address.Country)
+	if country == "" {
+		return address, invalidArgumentError("country", "Address country is required")
+	}
+	if address.Street == "" {
+		return address, invalidArgumentError("street", "Address street is required")
+	}
+	address.Country = country
+	rules, ok := countryAddressRules[country]
+	if !ok {
+		if address.ZipCode == "" || address.State == "" {
+			return address, invalidArgumentError("zipCode", "Address zip code and state are required")
+		}
+		return address, nil
+	}
+	if !rules.ZipCode.MatchString(address.ZipCode) {
+		return address, invalidArgumentError("zipCode", "Invalid zip code %s for country %s", address.ZipCode, country)
+	}
+	if rules.States != nil {
+		state, ok := findState(rules.States, address.State)
+		if !ok {
+			return address, invalidArgumentError("state", "Invalid state %s for country %s", address.State, country)
+		}
+		address.State = state
+	} else if address.State == "" {
+		return address, invalidArgumentError("state", "Address state is required")
+	}
+	return address, nil
+}
+
+//Function to get the code of a supported country from any of its spellings, other
+//countries are only upper cased
+func normalizeCountry(country string) string {
+	country = strings.ToUpper(strings.TrimSpace(country))
+	if code, ok := countryAliases[country]; ok {
+		return code
+	}
+	return country
+}
+
+//Function to find a state by code or name, returning its full name
+func findState(states map[string]string, state string) (string, bool) {
+	if name, ok := states[strings.ToUpper(state)]; ok {
+		return name, true
+	}
+	for _, name := range states {
+		if strings.EqualFold(name, state) {
+			return name, true
+		}
+	}
+	return "", false
+}
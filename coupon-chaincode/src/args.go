@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Types of the invoke function arguments
+const (
+	argString  = "string"
+	argInt     = "int"
+	argNumber  = "number"
+	argDecimal = "decimal"
+	argBool    = "bool"
+	argArray   = "array"
+	argObject  = "object"
+)
+
+// Formats checked on top of the argument type. Dates use dateFormat, date times
+// dateTimeFormat, amounts must not be negative, percents lie between 0 and 100 and
+// keys must start with one of the record type prefixes of the argument.
+const (
+	formatDate           = "date"
+	formatDateTime       = "dateTime"
+	formatAmount         = "amount"
+	formatPositiveAmount = "positiveAmount"
+	formatPercent        = "percent"
+	formatKey            = "key"
+)
+
+const (
+	argRequired = true
+	argOptional = false
+)
+
+// Int arguments lie between Min and Max, by default between 0 and the largest int32
+// as every int argument is a count, a size or a version.
+type argField struct {
+	Name        string
+	Type        string
+	Required    bool
+	Format      string
+	KeyPrefixes []string
+	Min         int64
+	Max         int64
+}
+
+// The arguments of an invoke function are passed either as a single JSON object or
+// positionally in the order of the fields, e.g. ["Big Sale","31-12-2019","10.5"].
+// A single argument holding a JSON object is the JSON form, unless the first field
+// is an object itself and the argument has keys which are not fields of the schema.
+// Fields missing from the schema are rejected.
+type argSchema []argField
+
+// Prefixes of every record type which can be looked up by key
+var recordKeyPrefixes = []string{couponKeyPrefix, customerKeyPrefix, salesTransactionKeyPrefix, partnerKeyPrefix,
+	addressKeyPrefix, campaignKeyPrefix, settlementBatchKeyPrefix, partnerContractKeyPrefix, currencyKeyPrefix}
+
+// Arguments accepted by each invoke function
+var functionArgs = map[string]argSchema{
+	"createcoupon": {
+		arg("name", argString, argRequired),
+		formattedArg("expiresOn", argString, formatDate, argRequired),
+		formattedArg("discountAmount", argDecimal, formatAmount, argOptional),
+		keyArg("customerKey", argRequired, customerKeyPrefix),
+		arg("discountType", argString, argOptional),
+		formattedArg("discountPercent", argDecimal, formatPercent, argOptional),
+		formattedArg("maxDiscountAmount", argDecimal, formatAmount, argOptional),
+		formattedArg("minimumPurchaseAmount", argDecimal, formatAmount, argOptional),
+		arg("excessDiscountPolicy", argString, argOptional),
+		formattedArg("revenueSharePercent", argDecimal, formatPercent, argOptional),
+		arg("currency", argString, argOptional),
+		arg("maxUses", argInt, argOptional),
+		keyArg("campaignKey", argOptional, campaignKeyPrefix),
+		arg("eligiblePartnerKeys", argArray, argOptional),
+		arg("eligiblePartnerGroups", argArray, argOptional),
+		arg("eligiblePartnerCategories", argArray, argOptional),
+		arg("allowedRegions", argArray, argOptional),
+		arg("transferable", argBool, argOptional),
+		arg("timeZone", argString, argOptional),
+	},
+	"createsalestransaction": {
+		keyArg("partnerKey", argOptional, partnerKeyPrefix),
+		keyArg("couponKey", argOptional, couponKeyPrefix),
+		intArg("useNumber", argOptional, 1, math.MaxInt32),
+		formattedArg("assetOriginalPrice", argDecimal, formatAmount, argOptional),
+		formattedArg("discountAmount", argDecimal, formatAmount, argOptional),
+		arg("salesAmount", argDecimal, argOptional),
+		arg("revenueShareAmount", argDecimal, argOptional),
+		formattedArg("revenueSharePercent", argDecimal, formatPercent, argOptional),
+		arg("shareBasis", argString, argOptional),
+		keyArg("contractKey", argOptional, partnerContractKeyPrefix),
+		intArg("contractVersion", argOptional, 1, math.MaxInt32),
+		arg("settlementAmount", argDecimal, argOptional),
+		arg("currency", argString, argOptional),
+		formattedArg("createdDateTime", argString, formatDateTime, argOptional),
+	},
+	"querybykey": {
+		keyArg("key", argRequired, recordKeyPrefixes...),
+	},
+	"querybyrange": {
+		arg("recordType", argString, argRequired),
+		intArg("pageSize", argOptional, 0, int64(maxPageSize)),
+		arg("bookmark", argString, argOptional),
+	},
+	"validatecoupon": {
+		keyArg("couponKey", argRequired, couponKeyPrefix),
+		keyArg("customerKey", argRequired, customerKeyPrefix),
+		keyArg("partnerKey", argOptional, partnerKeyPrefix),
+		formattedArg("assetOriginalPrice", argDecimal, formatAmount, argOptional),
+	},
+	"redeemcoupon": {
+		keyArg("couponKey", argRequired, couponKeyPrefix),
+		keyArg("customerKey", argRequired, customerKeyPrefix),
+		keyArg("partnerKey", argRequired, partnerKeyPrefix),
+		formattedArg("assetOriginalPrice", argDecimal, formatAmount, argRequired),
+		arg("requestId", argString, argOptional),
+	},
+	"deleterecord": {
+		keyArg("key", argRequired, recordKeyPrefixes...),
+	},
+	"queryhistorybykey": {
+		keyArg("key", argRequired, recordKeyPrefixes...),
+	},
+	"querycouponsbycustomer": {
+		keyArg("key", argRequired, customerKeyPrefix),
+		arg("status", argString, argOptional),
+		intArg("pageSize", argOptional, 0, int64(maxPageSize)),
+		arg("bookmark", argString, argOptional),
+	},
+	"querycustomercoupons": {
+		keyArg("key", argRequired, customerKeyPrefix),
+		arg("status", argString, argOptional),
+		intArg("pageSize", argOptional, 0, int64(maxPageSize)),
+		arg("bookmark", argString, argOptional),
+	},
+	"setcurrencyconfig": {
+		arg("code", argString, argRequired),
+		intArg("scale", argOptional, 0, maxCurrencyScale),
+		arg("roundingMode", argString, argOptional),
+	},
+	"queryrecords": {
+		arg("recordType", argString, argRequired),
+		arg("selector", argObject, argRequired),
+		arg("sort", argArray, argOptional),
+		arg("fields", argArray, argOptional),
+		intArg("limit", argOptional, 0, int64(maxQueryLimit)),
+		intArg("pageSize", argOptional, 0, int64(maxPageSize)),
+		arg("bookmark", argString, argOptional),
+	},
+	"createcustomer": {},
+	"updatecustomer": {
+		keyArg("key", argRequired, customerKeyPrefix),
+		intArg("version", argRequired, 1, math.MaxInt32),
+	},
+	"deactivatecustomer": {
+		keyArg("key", argRequired, customerKeyPrefix),
+		intArg("version", argRequired, 1, math.MaxInt32),
+	},
+	"getcustomerbyemail": {
+		arg("email", argString, argRequired),
+	},
+	"registerpartner": {
+		arg("name", argString, argRequired),
+		keyArg("addressKey", argRequired, addressKeyPrefix),
+		arg("group", argString, argOptional),
+		arg("category", argString, argOptional),
+	},
+	"updatepartner": {
+		keyArg("key", argRequired, partnerKeyPrefix),
+		arg("name", argString, argRequired),
+		keyArg("addressKey", argRequired, addressKeyPrefix),
+		arg("group", argString, argOptional),
+		arg("category", argString, argOptional),
+	},
+	"suspendpartner": {
+		keyArg("key", argRequired, partnerKeyPrefix),
+	},
+	"createaddress": {
+		arg("street", argString, argRequired),
+		arg("zipCode", argString, argOptional),
+		arg("state", argString, argOptional),
+		arg("country", argString, argRequired),
+	},
+	"updateaddress": {
+		keyArg("key", argRequired, addressKeyPrefix),
+		arg("street", argString, argRequired),
+		arg("zipCode", argString, argOptional),
+		arg("state", argString, argOptional),
+		arg("country", argString, argRequired),
+	},
+	"rebuildcouponindex":     {},
+	"setemailindexsecret":    {},
+	"rebuildsettlementindex": {},
+	"setaccesspolicy": {
+		arg("roleMSPs", argObject, argRequired),
+	},
+	"transfercoupon": {
+		keyArg("couponKey", argRequired, couponKeyPrefix),
+		keyArg("toCustomerKey", argRequired, customerKeyPrefix),
+	},
+	"createcouponsbatch": {
+		arg("template", argObject, argOptional),
+		arg("customerKeys", argArray, argOptional),
+		arg("coupons", argArray, argOptional),
+	},
+	"createcampaign": {
+		arg("name", argString, argRequired),
+		formattedArg("startDate", argString, formatDate, argRequired),
+		formattedArg("endDate", argString, formatDate, argRequired),
+		formattedArg("totalBudget", argDecimal, formatPositiveAmount, argRequired),
+		arg("currency", argString, argOptional),
+		arg("timeZone", argString, argOptional),
+		arg("maxCoupons", argInt, argOptional),
+		arg("perCustomerLimit", argInt, argOptional),
+	},
+	"createsettlementbatch": {
+		keyArg("partnerKey", argRequired, partnerKeyPrefix),
+		arg("currency", argString, argOptional),
+		formattedArg("cutOffDateTime", argString, formatDateTime, argOptional),
+	},
+	"updatesettlementbatchstatus": {
+		keyArg("key", argRequired, settlementBatchKeyPrefix),
+		arg("status", argString, argRequired),
+		arg("reason", argString, argOptional),
+	},
+	"reverseredemption": {
+		keyArg("salesTransactionKey", argRequired, salesTransactionKeyPrefix),
+		formattedArg("refundAmount", argDecimal, formatPositiveAmount, argOptional),
+	},
+	"createpartnercontract": {
+		keyArg("partnerKey", argRequired, partnerKeyPrefix),
+		formattedArg("effectiveFrom", argString, formatDate, argRequired),
+		formattedArg("effectiveTo", argString, formatDate, argOptional),
+		arg("timeZone", argString, argOptional),
+		arg("shareBasis", argString, argOptional),
+		formattedArg("revenueSharePercent", argDecimal, formatPercent, argOptional),
+		arg("tiers", argArray, argOptional),
+	},
+}
+
+func arg(name string, argType string, required bool) argField {
+	if argType == argInt {
+		return intArg(name, required, 0, math.MaxInt32)
+	}
+	return argField{Name: name, Type: argType, Required: required}
+}
+
+func intArg(name string, required bool, min int64, max int64) argField {
+	return argField{Name: name, Type: argInt, Required: required, Min: min, Max: max}
+}
+
+func formattedArg(name string, argType string, format string, required bool) argField {
+	return argField{Name: name, Type: argType, Format: format, Required: required}
+}
+
+func keyArg(name string, required bool, keyPrefixes ...string) argField {
+	return argField{Name: name, Type: argString, Format: formatKey, Required: required, KeyPrefixes: keyPrefixes}
+}
+
+//Function to find a field of the schema, names match case insensitively like encoding/json
+func (schema argSchema) field(name string) (argField, bool) {
+	for _, field := range schema {
+		if strings.EqualFold(field.Name, name) {
+			return field, true
+		}
+	}
+	return argField{}, false
+}
+
+//Function to validate the arguments of an invoke function against its schema and
+//decode them into target, target is nil for functions which take no arguments
+func parseArgs(fnc string, args []string, target interface{}) error {
+	schema, ok := functionArgs[fnc]
+	if !ok {
+		return fmt.Errorf("No argument schema for function %s", fnc)
+	}
+	values, err := getArgValues(fnc, schema, args)
+	if err != nil {
+		return err
+	}
+	for _, field := range schema {
+		err = validateArg(field, values[field.Name])
+		if err != nil {
+			return err
+		}
+	}
+	if target == nil {
+		return nil
+	}
+	valuesAsBytes, err := json.Marshal(values)
+	if err != nil {
+		return invalidArgumentError("", "Invalid arguments of %s error : %s", fnc, err.Error())
+	}
+	decoder := json.NewDecoder(bytes.NewReader(valuesAsBytes))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(target)
+	if err != nil {
+		return invalidArgumentError("", "Invalid arguments of %s error : %s", fnc, err.Error())
+	}
+	return nil
+}
+
+//Function to collect the raw argument values by field name from a JSON object or
+//from positional arguments, unknown fields are rejected
+func getArgValues(fnc string, schema argSchema, args []string) (map[string]json.RawMessage, error) {
+	values := make(map[string]json.RawMessage)
+	if len(args) == 0 || (len(args) == 1 && strings.TrimSpace(args[0]) == "") {
+		return values, nil
+	}
+	if len(args) == 1 && strings.HasPrefix(strings.TrimSpace(args[0]), "{") {
+		var jsonValues map[string]json.RawMessage
+		err := json.Unmarshal([]byte(args[0]), &jsonValues)
+		if err != nil {
+			return nil, invalidArgumentError("", "Invalid arguments of %s error : %s", fnc, err.Error())
+		}
+		if !isPositionalObjectArg(schema, jsonValues) {
+			return getJSONArgValues(fnc, schema, jsonValues)
+		}
+	}
+	if len(args) > len(schema) {
+		return nil, invalidArgumentError("", "%s expects at most %d arguments, got %d", fnc, len(schema), len(args))
+	}
+	for i, value := range args {
+		if value == "" {
+			continue
+		}
+		field := schema[i]
+		if field.Type == argString {
+			values[field.Name], _ = json.Marshal(value)
+		} else {
+			//Numbers, booleans, arrays and objects are passed as JSON literals
+			values[field.Name] = json.RawMessage(strings.TrimSpace(value))
+		}
+	}
+	return values, nil
+}
+
+//Function to collect the raw argument values from the fields of a JSON object
+func getJSONArgValues(fnc string, schema argSchema, jsonValues map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	values := make(map[string]json.RawMessage)
+	for name, value := range jsonValues {
+		field, ok := schema.field(name)
+		if !ok {
+			return nil, invalidArgumentError(name, "Unknown argument %s of %s", name, fnc)
+		}
+		values[field.Name] = value
+	}
+	return values, nil
+}
+
+//Function to check whether a single JSON object argument is the first field of a
+//schema starting with an object, rather than the JSON form of all the arguments
+func isPositionalObjectArg(schema argSchema, jsonValues map[string]json.RawMessage) bool {
+	if len(schema) == 0 || schema[0].Type != argObject {
+		return false
+	}
+	for name := range jsonValues {
+		if _, ok := schema.field(name); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+//Function to validate the type and format of an argument value
+func validateArg(field argField, value json.RawMessage) error {
+	if len(value) == 0 || string(value) == "null" {
+		if field.Required {
+			return invalidArgumentError(field.Name, "Argument %s is required", field.Name)
+		}
+		return nil
+	}
+	typeError := invalidArgumentError(field.Name, "Argument %s must be of type %s : %s", field.Name, field.Type, string(value))
+	if !json.Valid(value) {
+		return typeError
+	}
+	switch field.Type {
+	case argString:
+		var stringValue string
+		if json.Unmarshal(value, &stringValue) != nil {
+			return typeError
+		}
+		if strings.TrimSpace(stringValue) == "" {
+			if field.Required {
+				return invalidArgumentError(field.Name, "Argument %s is required", field.Name)
+			}
+			return nil
+		}
+		return validateArgFormat(field, stringValue)
+	case argInt:
+		intValue, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return typeError
+		}
+		if intValue < field.Min || intValue > field.Max {
+			return invalidArgumentError(field.Name, "Argument %s must be between %d and %d : %d", field.Name, field.Min, field.Max, intValue)
+		}
+	case argNumber:
+		if _, err := strconv.ParseFloat(string(value), 64); err != nil {
+			return typeError
+		}
+	case argDecimal:
+		var amount decimal.Decimal
+		if json.Unmarshal(value, &amount) != nil {
+			return typeError
+		}
+		if field.Format == formatAmount && amount.IsNegative() {
+			return invalidArgumentError(field.Name, "Argument %s must not be negative : %s", field.Name, amount.String())
+		}
+		if field.Format == formatPositiveAmount && !amount.IsPositive() {
+			return invalidArgumentError(field.Name, "Argument %s must be positive : %s", field.Name, amount.String())
+		}
+		if field.Format == formatPercent && (amount.IsNegative() || amount.GreaterThan(decimal.New(100, 0))) {
+			return invalidArgumentError(field.Name, "Argument %s must be between 0 and 100 : %s", field.Name, amount.String())
+		}
+	case argBool:
+		if string(value) != "true" && string(value) != "false" {
+			return typeError
+		}
+	case argArray:
+		if value[0] != '[' {
+			return typeError
+		}
+	case argObject:
+		if value[0] != '{' {
+			return typeError
+		}
+	}
+	return nil
+}
+
+//Function to validate the format of a string argument
+func validateArgFormat(field argField, value string) error {
+	switch field.Format {
+	case formatDate:
+		if _, err := time.Parse(dateFormat, value); err != nil {
+			return invalidArgumentError(field.Name, "Argument %s must be a date (dd-mm-yyyy) : %s", field.Name, value)
+		}
+	case formatDateTime:
+		if _, err := time.Parse(dateTimeFormat, value); err != nil {
+			return invalidArgumentError(field.Name, "Argument %s must be an RFC 3339 date time : %s", field.Name, value)
+		}
+	case formatKey:
+		keyParts := strings.SplitN(strings.ToLower(value), ":", 2)
+		if len(keyParts) == 2 && keyParts[1] != "" {
+			for _, keyPrefix := range field.KeyPrefixes {
+				if keyParts[0] == keyPrefix {
+					return nil
+				}
+			}
+		}
+		return invalidArgumentError(field.Name, "Argument %s must be a %s key : %s", field.Name, strings.Join(field.KeyPrefixes, "/"), value)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateArg(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   argField
+		value   string
+		wantErr bool
+	}{
+		{"required missing", arg("name", argString, argRequired), "", true},
+		{"required null", arg("name", argString, argRequired), `null`, true},
+		{"required blank string", arg("name", argString, argRequired), `"  "`, true},
+		{"optional missing", arg("name", argString, argOptional), "", false},
+		{"optional blank string", arg("name", argString, argOptional), `""`, false},
+		{"string", arg("name", argString, argRequired), `"Big Sale"`, false},
+		{"string given a number", arg("name", argString, argRequired), `42`, true},
+		{"malformed json", arg("name", argString, argRequired), `"Big Sale`, true},
+		{"int", arg("maxUses", argInt, argOptional), `3`, false},
+		{"int given a fraction", arg("maxUses", argInt, argOptional), `1.5`, true},
+		{"int given a string", arg("maxUses", argInt, argOptional), `"3"`, true},
+		{"int below zero", arg("maxUses", argInt, argOptional), `-1`, true},
+		{"int above int32", arg("maxUses", argInt, argOptional), `2147483648`, true},
+		{"int at its bound", intArg("pageSize", argOptional, 0, 1000), `1000`, false},
+		{"int above its bound", intArg("pageSize", argOptional, 0, 1000), `1001`, true},
+		{"int below its bound", intArg("version", argRequired, 1, 10), `0`, true},
+		{"number", arg("price", argNumber, argOptional), `12.5`, false},
+		{"decimal as string", arg("salesAmount", argDecimal, argOptional), `"12.50"`, false},
+		{"decimal as number", arg("salesAmount", argDecimal, argOptional), `12.50`, false},
+		{"decimal not a number", arg("salesAmount", argDecimal, argOptional), `"twelve"`, true},
+		{"amount zero", formattedArg("discountAmount", argDecimal, formatAmount, argOptional), `"0"`, false},
+		{"amount negative", formattedArg("discountAmount", argDecimal, formatAmount, argOptional), `"-0.01"`, true},
+		{"positive amount zero", formattedArg("totalBudget", argDecimal, formatPositiveAmount, argRequired), `"0"`, true},
+		{"positive amount", formattedArg("totalBudget", argDecimal, formatPositiveAmount, argRequired), `"0.01"`, false},
+		{"percent upper bound", formattedArg("discountPercent", argDecimal, formatPercent, argOptional), `"100"`, false},
+		{"percent above 100", formattedArg("discountPercent", argDecimal, formatPercent, argOptional), `"100.01"`, true},
+		{"percent negative", formattedArg("discountPercent", argDecimal, formatPercent, argOptional), `"-1"`, true},
+		{"bool", arg("transferable", argBool, argOptional), `true`, false},
+		{"bool given a string", arg("transferable", argBool, argOptional), `"true"`, true},
+		{"array", arg("allowedRegions", argArray, argOptional), `["US"]`, false},
+		{"array given an object", arg("allowedRegions", argArray, argOptional), `{"country":"US"}`, true},
+		{"object", arg("selector", argObject, argRequired), `{"status":"ISSUED"}`, false},
+		{"object given an array", arg("selector", argObject, argRequired), `[]`, true},
+		{"date", formattedArg("expiresOn", argString, formatDate, argRequired), `"31-12-2019"`, false},
+		{"date in the wrong layout", formattedArg("expiresOn", argString, formatDate, argRequired), `"2019-12-31"`, true},
+		{"date time", formattedArg("cutOffDateTime", argString, formatDateTime, argOptional), `"2019-12-31T23:59:59Z"`, false},
+		{"date time without zone", formattedArg("cutOffDateTime", argString, formatDateTime, argOptional), `"2019-12-31T23:59:59"`, true},
+		{"key", keyArg("customerKey", argRequired, customerKeyPrefix), `"customer:42"`, false},
+		{"key prefix is case insensitive", keyArg("customerKey", argRequired, customerKeyPrefix), `"Customer:42"`, false},
+		{"key of another record type", keyArg("customerKey", argRequired, customerKeyPrefix), `"coupon:42"`, true},
+		{"key without id", keyArg("customerKey", argRequired, customerKeyPrefix), `"customer:"`, true},
+		{"key of any listed type", keyArg("key", argRequired, recordKeyPrefixes...), `"settlementbatch:42"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateArg(tt.field, json.RawMessage(tt.value))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateArg(%s) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if err != nil {
+				chaincodeError, ok := err.(*ChaincodeError)
+				if !ok || chaincodeError.Code != errCodeInvalidArgument || chaincodeError.Field != tt.field.Name {
+					t.Errorf("validateArg(%s) error = %#v, want %s on %s", tt.value, err, errCodeInvalidArgument, tt.field.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	var request SettlementStatusRequest
+	err := parseArgs("updatesettlementbatchstatus", []string{"settlementbatch:42", "APPROVED"}, &request)
+	if err != nil || request.Key != "settlementbatch:42" || request.Status != "APPROVED" {
+		t.Errorf("parseArgs positional = %+v, %v", request, err)
+	}
+	request = SettlementStatusRequest{}
+	err = parseArgs("updatesettlementbatchstatus", []string{`{"key":"settlementbatch:42","status":"PAID"}`}, &request)
+	if err != nil || request.Key != "settlementbatch:42" || request.Status != "PAID" {
+		t.Errorf("parseArgs object = %+v, %v", request, err)
+	}
+	err = parseArgs("updatesettlementbatchstatus", []string{`{"key":"settlementbatch:42","status":"PAID","amount":"1"}`}, &request)
+	if err == nil {
+		t.Errorf("parseArgs accepted an unknown field")
+	}
+	err = parseArgs("updatesettlementbatchstatus", []string{`{"status":"PAID"}`}, &request)
+	if err == nil {
+		t.Errorf("parseArgs accepted a missing required field")
+	}
+}
+
+func TestParseArgsPositionalObject(t *testing.T) {
+	template := `{"name":"Big Sale","expiresOn":"31-12-2019","discountAmount":"10"}`
+	var request CouponBatchRequest
+	err := parseArgs("createcouponsbatch", []string{template, `["customer:1","customer:2"]`}, &request)
+	if err != nil || string(request.Template) != template || len(request.CustomerKeys) != 2 {
+		t.Errorf("parseArgs positional template and customers = %+v, %v", request, err)
+	}
+	request = CouponBatchRequest{}
+	err = parseArgs("createcouponsbatch", []string{template}, &request)
+	if err != nil || string(request.Template) != template {
+		t.Errorf("parseArgs positional template = %+v, %v", request, err)
+	}
+	request = CouponBatchRequest{}
+	err = parseArgs("createcouponsbatch", []string{`{"template":` + template + `,"customerKeys":["customer:1"]}`}, &request)
+	if err != nil || string(request.Template) != template || len(request.CustomerKeys) != 1 {
+		t.Errorf("parseArgs object = %+v, %v", request, err)
+	}
+}
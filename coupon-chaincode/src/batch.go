@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+const maxCouponBatchSize = 10000
+
+// CouponBatchRequest issues either one copy of Template per customer key or the
+// listed Coupons. Every entry takes the arguments of createCoupon. The batch is
+// written in one transaction, so either every coupon is created or none is.
+type CouponBatchRequest struct {
+	Template     json.RawMessage   `json:"template,omitempty"`
+	CustomerKeys []string          `json:"customerKeys,omitempty"`
+	Coupons      []json.RawMessage `json:"coupons,omitempty"`
+}
+
+type CouponBatchItemError struct {
+	Index       int    `json:"index"`
+	CustomerKey string `json:"customerKey,omitempty"`
+	Code        string `json:"code"`
+	Field       string `json:"field,omitempty"`
+	Message     string `json:"message"`
+}
+
+type CouponBatchResponse struct {
+	Keys   []string               `json:"keys,omitempty"`
+	Errors []CouponBatchItemError `json:"errors,omitempty"`
+}
+
+//Function to create a batch of coupons, the whole batch is rejected with the
+//errors of every invalid entry
+func (c *CouponChaincode) CreateCouponsBatch(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var request CouponBatchRequest
+	err := parseArgs("createcouponsbatch", args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+	entries, err := getBatchCoupons(request)
+	if err != nil {
+		return errorResponse(err)
+	}
+	coupons := make([]Coupon, len(entries))
+	now, err := c.clock(stub).Now()
+	if err != nil {
+		return errorResponse(err)
+	}
+	issuance := newCampaignIssuance(stub, now)
+	var response CouponBatchResponse
+	seenCustomerKeys := make(map[string]bool)
+	for i := range coupons {
+		err = parseArgs("createcoupon", []string{string(entries[i])}, &coupons[i])
+		if err == nil {
+			err = validateNewCoupon(&coupons[i])
+		}
+		if err == nil {
+			err = checkCouponCustomer(stub, coupons[i])
+		}
+		if err == nil && request.Template != nil {
+			if coupons[i].CustomerKey == "" {
+				err = invalidArgumentError("customerKeys", "Customer key is required")
+			} else if seenCustomerKeys[coupons[i].CustomerKey] {
+				err = invalidArgumentError("customerKeys", "Duplicate customer key %s", coupons[i].CustomerKey)
+			}
+			seenCustomerKeys[coupons[i].CustomerKey] = true
+		}
+		if err == nil {
+			err = issuance.add(&coupons[i])
+		}
+		if err != nil {
+			itemError := CouponBatchItemError{
+				Index:       i,
+				CustomerKey: coupons[i].CustomerKey,
+				Code:        errCodeInternal,
+				Message:     err.Error(),
+			}
+			if request.Template != nil {
+				itemError.CustomerKey = request.CustomerKeys[i]
+			}
+			if chaincodeError, ok := err.(*ChaincodeError); ok {
+				itemError.Code = chaincodeError.Code
+				itemError.Field = chaincodeError.Field
+			}
+			response.Errors = append(response.Errors, itemError)
+		}
+	}
+	if len(response.Errors) > 0 {
+		return errorResponse(invalidArgumentError("coupons", "%d of %d coupons in the batch are invalid", len(response.Errors), len(coupons)).withDetails(response.Errors))
+	}
+	for i := range coupons {
+		err = putNewCoupon(stub, &coupons[i])
+		if err != nil {
+			return errorResponse(err)
+		}
+		response.Keys = append(response.Keys, coupons[i].Key)
+	}
+	err = issuance.save()
+	if err != nil {
+		return errorResponse(err)
+	}
+	responseAsBytes, _ := json.Marshal(response)
+	return shim.Success(responseAsBytes)
+}
+
+//Function to expand the batch request into the createCoupon arguments of every coupon
+func getBatchCoupons(request CouponBatchRequest) ([]json.RawMessage, error) {
+	var coupons []json.RawMessage
+	if request.Template != nil {
+		if len(request.Coupons) > 0 {
+			return nil, invalidArgumentError("template", "A coupon batch takes either a template with customer keys or coupons, not both")
+		}
+		var template map[string]json.RawMessage
+		err := json.Unmarshal(request.Template, &template)
+		if err != nil || template == nil {
+			return nil, invalidArgumentError("template", "Invalid coupon template %s", string(request.Template))
+		}
+		for _, customerKey := range request.CustomerKeys {
+			template["customerKey"], _ = json.Marshal(strings.TrimSpace(customerKey))
+			coupon, _ := json.Marshal(template)
+			coupons = append(coupons, coupon)
+		}
+	} else {
+		if len(request.CustomerKeys) > 0 {
+			return nil, invalidArgumentError("customerKeys", "Customer keys require a coupon template")
+		}
+		coupons = request.Coupons
+	}
+	if len(coupons) == 0 {
+		return nil, invalidArgumentError("coupons", "A coupon batch must contain at least one coupon")
+	}
+	if len(coupons) > maxCouponBatchSize {
+		return nil, invalidArgumentError("coupons", "A coupon batch must not exceed %d coupons", maxCouponBatchSize)
+	}
+	return coupons, nil
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	campaignKeyPrefix = "campaign"
+	// Index of the coupons issued per customer in a campaign, used for the per
+	// customer limit. A transfer moves the entry of the coupon to the new owner.
+	campaignCustomerIndex = "campaign~customer~coupon"
+
+	reasonCampaignBudgetExceeded = "CAMPAIGN_BUDGET_EXCEEDED"
+)
+
+// Campaign groups coupons under a discount budget. DiscountLiability is the most
+// the issued coupons can discount; PERCENTAGE coupons have no upper bound and are
+// only checked against the budget when they are redeemed. MaxCoupons and
+// PerCustomerLimit of zero mean no limit. Every issuance and redemption updates
+// the running totals, so they conflict with other transactions of the campaign.
+type Campaign struct {
+	Key               string          `json:"key"`
+	Name              string          `json:"name"`
+	OwnerMSPID        string          `json:"ownerMspId"`
+	StartDate         string          `json:"startDate"`
+	EndDate           string          `json:"endDate"`
+	TimeZone          string          `json:"timeZone,omitempty"`
+	Currency          string          `json:"currency"`
+	TotalBudget       decimal.Decimal `json:"totalBudget"`
+	MaxCoupons        int             `json:"maxCoupons,omitempty"`
+	PerCustomerLimit  int             `json:"perCustomerLimit,omitempty"`
+	IssuedCount       int             `json:"issuedCount"`
+	RedeemedCount     int             `json:"redeemedCount"`
+	DiscountLiability decimal.Decimal `json:"discountLiability"`
+	DiscountSpent     decimal.Decimal `json:"discountSpent"`
+}
+
+//Function to create a campaign owned by the MSP of the caller
+func (c *CouponChaincode) CreateCampaign(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var campaign Campaign
+	err := parseArgs("createcampaign", args, &campaign)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = validateCampaign(&campaign)
+	if err != nil {
+		return errorResponse(err)
+	}
+	campaign.Key = generateKey(stub, campaignKeyPrefix)
+	campaign.OwnerMSPID = getCaller(stub).MSPID
+	campaign.IssuedCount = 0
+	campaign.RedeemedCount = 0
+	campaign.DiscountLiability = decimal.Zero
+	campaign.DiscountSpent = decimal.Zero
+	err = putCampaign(stub, campaign)
+	if err != nil {
+		return errorResponse(err)
+	}
+	campaignAsBytes, _ := json.Marshal(campaign)
+	return shim.Success(campaignAsBytes)
+}
+
+//Function to get a campaign
+func getCampaign(stub shim.ChaincodeStubInterface, campaignKey string) (Campaign, bool, error) {
+	var campaign Campaign
+	campaignKey = strings.ToLower(campaignKey)
+	resultAsBytes, err := stub.GetState(campaignKey)
+	if err != nil {
+		return campaign, false, fmt.Errorf("Unable to fetch campaign %s error : %s", campaignKey, err.Error())
+	}
+	if resultAsBytes == nil {
+		return campaign, false, nil
+	}
+	err = json.Unmarshal(resultAsBytes, &campaign)
+	if err != nil {
+		return campaign, false, fmt.Errorf("Invalid campaign record %s error : %s", campaignKey, err.Error())
+	}
+	campaign.Key = campaignKey
+	return campaign, true, nil
+}
+
+func putCampaign(stub shim.ChaincodeStubInterface, campaign Campaign) error {
+	campaignAsBytes, _ := json.Marshal(campaign)
+	err := stub.PutState(campaign.Key, campaignAsBytes)
+	if err != nil {
+		return fmt.Errorf("Campaign %s PutState failed: %s", campaign.Key, err.Error())
+	}
+	return nil
+}
+
+//Function to validate the campaign fields
+func validateCampaign(campaign *Campaign) error {
+	campaign.Name = strings.TrimSpace(campaign.Name)
+	if campaign.Name == "" {
+		return invalidArgumentError("name", "Campaign name is required")
+	}
+	location, err := loadIssuerLocation(campaign.TimeZone)
+	if err != nil {
+		return err
+	}
+	startDate, err := time.ParseInLocation(dateFormat, campaign.StartDate, location)
+	if err != nil {
+		return invalidArgumentError("startDate", "Invalid campaign start date : %s", campaign.StartDate)
+	}
+	endDate, err := time.ParseInLocation(dateFormat, campaign.EndDate, location)
+	if err != nil {
+		return invalidArgumentError("endDate", "Invalid campaign end date : %s", campaign.EndDate)
+	}
+	if endDate.Before(startDate) {
+		return invalidArgumentError("endDate", "Campaign end date %s is before its start date %s", campaign.EndDate, campaign.StartDate)
+	}
+	if !campaign.TotalBudget.IsPositive() {
+		return invalidArgumentError("totalBudget", "Campaign total budget must be positive")
+	}
+	if campaign.MaxCoupons < 0 || campaign.PerCustomerLimit < 0 {
+		return invalidArgumentError("maxCoupons", "Campaign coupon limits must not be negative")
+	}
+	campaign.Currency = normalizeCurrencyCode(campaign.Currency)
+	return nil
+}
+
+//Function to check whether the campaign runs at the time in its time zone
+func isCampaignActive(campaign Campaign, now time.Time) (bool, error) {
+	location, err := loadIssuerLocation(campaign.TimeZone)
+	if err != nil {
+		return false, err
+	}
+	startDate, err := time.ParseInLocation(dateFormat, campaign.StartDate, location)
+	if err != nil {
+		return false, fmt.Errorf("Invalid campaign start date : %s", campaign.StartDate)
+	}
+	if now.Before(startDate) {
+		return false, nil
+	}
+	hasEnded, err := hasCouponExpired(campaign.EndDate, campaign.TimeZone, now)
+	if err != nil {
+		return false, err
+	}
+	return !hasEnded, nil
+}
+
+//Function to get the most a coupon can discount over all its uses, PERCENTAGE
+//coupons are not bounded
+func getMaxCouponDiscount(coupon Coupon) (decimal.Decimal, bool) {
+	uses := decimal.New(int64(getMaxUses(coupon)), 0)
+	switch coupon.DiscountType {
+	case discountTypeStoredValue:
+		return coupon.DiscountAmount, true
+	case discountTypePercentageCapped:
+		return coupon.MaxDiscountAmount.Mul(uses), true
+	case discountTypePercentage:
+		return decimal.Zero, false
+	default:
+		return coupon.DiscountAmount.Mul(uses), true
+	}
+}
+
+// campaignIssuance collects the coupons issued by a transaction so that the
+// totals of each campaign are checked against every coupon of a batch and are
+// written once. The ledger returns the committed value for keys written in the
+// same transaction, so the campaigns must not be read again before save.
+type campaignIssuance struct {
+	stub           shim.ChaincodeStubInterface
+	caller         callerIdentity
+	now            time.Time
+	campaigns      map[string]*Campaign
+	customerCounts map[string]int
+}
+
+func newCampaignIssuance(stub shim.ChaincodeStubInterface, now time.Time) *campaignIssuance {
+	return &campaignIssuance{
+		stub:           stub,
+		caller:         getCaller(stub),
+		now:            now,
+		campaigns:      make(map[string]*Campaign),
+		customerCounts: make(map[string]int),
+	}
+}
+
+//Function to check the coupon against the limits of its campaign and count it
+func (ci *campaignIssuance) add(coupon *Coupon) error {
+	coupon.CampaignKey = strings.ToLower(coupon.CampaignKey)
+	if coupon.CampaignKey == "" {
+		return nil
+	}
+	campaign, ok := ci.campaigns[coupon.CampaignKey]
+	if !ok {
+		loadedCampaign, found, err := getCampaign(ci.stub, coupon.CampaignKey)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return notFoundError(coupon.CampaignKey, "Campaign %s does not exist", coupon.CampaignKey).withField("campaignKey")
+		}
+		campaign = &loadedCampaign
+		ci.campaigns[coupon.CampaignKey] = campaign
+	}
+	if ci.caller.Role != roleAdmin && ci.caller.MSPID != campaign.OwnerMSPID {
+		return forbiddenError(campaign.Key, "Access denied : campaign %s is owned by %s", campaign.Key, campaign.OwnerMSPID)
+	}
+	isActive, err := isCampaignActive(*campaign, ci.now)
+	if err != nil {
+		return err
+	}
+	if !isActive {
+		return conflictError(campaign.Key, "Campaign %s runs from %s to %s", campaign.Key, campaign.StartDate, campaign.EndDate)
+	}
+	if normalizeCurrencyCode(coupon.Currency) != campaign.Currency {
+		return invalidArgumentError("currency", "Coupon currency %s does not match campaign currency %s", normalizeCurrencyCode(coupon.Currency), campaign.Currency)
+	}
+	if campaign.MaxCoupons > 0 && campaign.IssuedCount >= campaign.MaxCoupons {
+		return conflictError(campaign.Key, "Campaign %s has reached its limit of %d coupons", campaign.Key, campaign.MaxCoupons)
+	}
+	maxDiscount, bounded := getMaxCouponDiscount(*coupon)
+	if bounded && campaign.DiscountLiability.Add(maxDiscount).GreaterThan(campaign.TotalBudget) {
+		return conflictError(campaign.Key, "Campaign %s budget of %s would be exceeded", campaign.Key, campaign.TotalBudget)
+	}
+	if campaign.PerCustomerLimit > 0 {
+		countKey := campaign.Key + "/" + coupon.CustomerKey
+		count, ok := ci.customerCounts[countKey]
+		if !ok {
+			count, err = countCampaignCustomerCoupons(ci.stub, campaign.Key, coupon.CustomerKey)
+			if err != nil {
+				return err
+			}
+		}
+		if count >= campaign.PerCustomerLimit {
+			return conflictError(campaign.Key, "Customer %s has reached the limit of %d coupons for campaign %s", coupon.CustomerKey, campaign.PerCustomerLimit, campaign.Key)
+		}
+		ci.customerCounts[countKey] = count + 1
+	}
+	campaign.IssuedCount++
+	if bounded {
+		campaign.DiscountLiability = campaign.DiscountLiability.Add(maxDiscount)
+	}
+	return nil
+}
+
+//Function to write the updated totals of the campaigns
+func (ci *campaignIssuance) save() error {
+	for _, campaign := range ci.campaigns {
+		err := putCampaign(ci.stub, *campaign)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Function to check that the discount fits in the remaining budget of the campaign
+func checkCampaignBudget(stub shim.ChaincodeStubInterface, coupon Coupon, discountAmount decimal.Decimal) (*discountRejection, error) {
+	if coupon.CampaignKey == "" {
+		return nil, nil
+	}
+	campaign, found, err := getCampaign(stub, coupon.CampaignKey)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("Campaign %s of coupon %s does not exist", coupon.CampaignKey, coupon.Key)
+	}
+	if campaign.DiscountSpent.Add(discountAmount).GreaterThan(campaign.TotalBudget) {
+		return &discountRejection{
+			Reason:  reasonCampaignBudgetExceeded,
+			Message: fmt.Sprintf("Campaign %s has %s of its budget left", campaign.Key, campaign.TotalBudget.Sub(campaign.DiscountSpent)),
+		}, nil
+	}
+	return nil, nil
+}
+
+//Function to add redemptions to the totals of the campaign of the coupon, a
+//reversal passes negative values
+func recordCampaignRedemption(stub shim.ChaincodeStubInterface, coupon Coupon, redemptions int, discountAmount decimal.Decimal) error {
+	if coupon.CampaignKey == "" {
+		return nil
+	}
+	campaign, found, err := getCampaign(stub, coupon.CampaignKey)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("Campaign %s of coupon %s does not exist", coupon.CampaignKey, coupon.Key)
+	}
+	campaign.RedeemedCount += redemptions
+	campaign.DiscountSpent = campaign.DiscountSpent.Add(discountAmount)
+	return putCampaign(stub, campaign)
+}
+
+func putCampaignCustomerIndex(stub shim.ChaincodeStubInterface, coupon Coupon) error {
+	indexKey, err := stub.CreateCompositeKey(campaignCustomerIndex, []string{coupon.CampaignKey, coupon.CustomerKey, coupon.Key})
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(indexKey, indexEntryValue)
+	if err != nil {
+		return fmt.Errorf("Unable to write campaign index for coupon %s error : %s", coupon.Key, err.Error())
+	}
+	return nil
+}
+
+func delCampaignCustomerIndex(stub shim.ChaincodeStubInterface, coupon Coupon) error {
+	indexKey, err := stub.CreateCompositeKey(campaignCustomerIndex, []string{coupon.CampaignKey, coupon.CustomerKey, coupon.Key})
+	if err != nil {
+		return err
+	}
+	err = stub.DelState(indexKey)
+	if err != nil {
+		return fmt.Errorf("Unable to delete campaign index for coupon %s error : %s", coupon.Key, err.Error())
+	}
+	return nil
+}
+
+//Function to move a campaign coupon to the new owner, the new owner must stay
+//within the per customer limit of the campaign
+func transferCampaignCoupon(stub shim.ChaincodeStubInterface, previous Coupon, current Coupon) error {
+	if current.CampaignKey == "" {
+		return nil
+	}
+	campaign, found, err := getCampaign(stub, current.CampaignKey)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("Campaign %s of coupon %s does not exist", current.CampaignKey, current.Key)
+	}
+	if campaign.PerCustomerLimit > 0 {
+		count, err := countCampaignCustomerCoupons(stub, campaign.Key, current.CustomerKey)
+		if err != nil {
+			return err
+		}
+		if count >= campaign.PerCustomerLimit {
+			return conflictError(campaign.Key, "Customer %s has reached the limit of %d coupons for campaign %s", current.CustomerKey, campaign.PerCustomerLimit, campaign.Key).withField("toCustomerKey")
+		}
+	}
+	err = delCampaignCustomerIndex(stub, previous)
+	if err != nil {
+		return err
+	}
+	return putCampaignCustomerIndex(stub, current)
+}
+
+//Function to take a deleted coupon out of the issuance totals of its campaign. Only
+//the unspent part of its liability is released, the discount already given stays
+//in the liability just as it stays in the discount spent.
+func releaseCampaignCoupon(stub shim.ChaincodeStubInterface, coupon Coupon) error {
+	if coupon.CampaignKey == "" {
+		return nil
+	}
+	err := delCampaignCustomerIndex(stub, coupon)
+	if err != nil {
+		return err
+	}
+	campaign, found, err := getCampaign(stub, coupon.CampaignKey)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+	campaign.IssuedCount--
+	maxDiscount, bounded := getMaxCouponDiscount(coupon)
+	if bounded {
+		redeemedDiscount, err := getCouponRedeemedDiscount(stub, coupon)
+		if err != nil {
+			return err
+		}
+		unspentDiscount := decimal.Max(maxDiscount.Sub(redeemedDiscount), decimal.Zero)
+		campaign.DiscountLiability = campaign.DiscountLiability.Sub(unspentDiscount)
+	}
+	return putCampaign(stub, campaign)
+}
+
+//Function to get the discount given by the uses of the coupon net of reversals
+func getCouponRedeemedDiscount(stub shim.ChaincodeStubInterface, coupon Coupon) (decimal.Decimal, error) {
+	redeemedDiscount := decimal.Zero
+	for _, salesTransactionKey := range coupon.SalesTransactionKeys {
+		salesTransaction, found, err := getSalesTransaction(stub, salesTransactionKey)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		if !found {
+			continue
+		}
+		redeemedDiscount = redeemedDiscount.Add(salesTransaction.DiscountAmount)
+		for _, reversalKey := range salesTransaction.ReversalKeys {
+			reversal, found, err := getSalesTransaction(stub, reversalKey)
+			if err != nil {
+				return decimal.Zero, err
+			}
+			if found {
+				redeemedDiscount = redeemedDiscount.Add(reversal.DiscountAmount)
+			}
+		}
+	}
+	return redeemedDiscount, nil
+}
+
+//Function to count the coupons issued to the customer in the campaign
+func countCampaignCustomerCoupons(stub shim.ChaincodeStubInterface, campaignKey string, customerKey string) (int, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(campaignCustomerIndex, []string{campaignKey, customerKey})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+	count := 0
+	for resultsIterator.HasNext() {
+		_, err = resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+//Function to check whether any coupon has been issued in the campaign
+func hasCampaignCoupons(stub shim.ChaincodeStubInterface, campaignKey string) (bool, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(campaignCustomerIndex, []string{campaignKey})
+	if err != nil {
+		return false, err
+	}
+	defer resultsIterator.Close()
+	return resultsIterator.HasNext(), nil
+}
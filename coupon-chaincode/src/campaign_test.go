@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDeletingCampaignCouponReleasesUnspentLiability(t *testing.T) {
+	ledger := newTestLedger(t, "2019-06-15T10:00:00Z")
+	customerKey := "customer:alice"
+	partner := ledger.seedPartnerAndCustomer(customerKey)
+	var campaign Campaign
+	ledger.mustInvoke(testIssuer, "createcampaign", map[string]string{
+		"name":        "Summer Campaign",
+		"startDate":   "01-06-2019",
+		"endDate":     "31-08-2019",
+		"totalBudget": "100",
+	}, &campaign)
+	created := ledger.mustInvoke(testIssuer, "createcoupon", map[string]interface{}{
+		"name":                "Summer Sale",
+		"expiresOn":           "31-12-2019",
+		"discountAmount":      "10",
+		"revenueSharePercent": "10",
+		"maxUses":             3,
+		"customerKey":         customerKey,
+		"campaignKey":         campaign.Key,
+	}, nil)
+	couponKey := strings.TrimSuffix(string(created), " created successfully")
+	ledger.mustRedeem(partner, couponKey, customerKey, "100")
+	sale := ledger.mustRedeem(partner, couponKey, customerKey, "100").SalesTransaction
+	ledger.mustInvoke(partner, "reverseredemption", map[string]string{"salesTransactionKey": sale.Key, "refundAmount": "50"}, nil)
+
+	ledger.mustInvoke(testAdmin, "deleterecord", map[string]string{"key": couponKey}, nil)
+	ledger.mustInvoke(testAdmin, "querybykey", map[string]string{"key": campaign.Key}, &campaign)
+	if campaign.IssuedCount != 0 {
+		t.Errorf("issued count = %d, want 0", campaign.IssuedCount)
+	}
+	assertAmount(t, "discount spent", campaign.DiscountSpent, "15")
+	assertAmount(t, "discount liability", campaign.DiscountLiability, "15")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Clock supplies the current time to the business rules. Time based rules must
+// never use time.Now() because endorsing peers would disagree near day
+// boundaries; the default clock returns the transaction proposal timestamp,
+// which is identical on every endorser.
+type Clock interface {
+	Now() (time.Time, error)
+}
+
+// ClockFactory builds the clock used for a single transaction.
+type ClockFactory func(stub shim.ChaincodeStubInterface) Clock
+
+const (
+	defaultIssuerTimeZone = "UTC"
+	dateTimeFormat        = time.RFC3339
+	sortableDateFormat    = "2006-01-02"
+)
+
+type txTimestampClock struct {
+	stub shim.ChaincodeStubInterface
+}
+
+//Function to get the transaction timestamp set by the client in the proposal
+func (t txTimestampClock) Now() (time.Time, error) {
+	txTimestamp, err := t.stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Unable to read transaction timestamp error : %s", err.Error())
+	}
+	if txTimestamp == nil {
+		return time.Time{}, fmt.Errorf("Transaction timestamp is missing")
+	}
+	return time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC(), nil
+}
+
+func newTxTimestampClock(stub shim.ChaincodeStubInterface) Clock {
+	return txTimestampClock{stub: stub}
+}
+
+//Function to get the clock for the transaction
+func (c *CouponChaincode) clock(stub shim.ChaincodeStubInterface) Clock {
+	if c.clockFactory != nil {
+		return c.clockFactory(stub)
+	}
+	return newTxTimestampClock(stub)
+}
+
+//Function to load the issuer time zone, defaulting to UTC when none is set
+func loadIssuerLocation(timeZone string) (*time.Location, error) {
+	if timeZone == "" {
+		timeZone = defaultIssuerTimeZone
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, invalidArgumentError("timeZone", "Invalid issuer time zone %s error : %s", timeZone, err.Error())
+	}
+	return location, nil
+}
+
+// Function to validate the coupon by date.
+// A coupon expiring on a date (dateFormat) stays valid for the whole of that day in
+// the issuer time zone: it expires at 00:00:00 of the following day, so a
+// transaction timestamped exactly at that instant or later sees it as expired.
+func hasCouponExpired(expiresOn string, timeZone string, now time.Time) (bool, error) {
+	location, err := loadIssuerLocation(timeZone)
+	if err != nil {
+		return false, err
+	}
+	expiryDate, err := time.ParseInLocation(dateFormat, expiresOn, location)
+	if err != nil {
+		return false, invalidArgumentError("expiresOn", "Invalid Coupon expiry date : %s", expiresOn)
+	}
+	expiresAt := expiryDate.AddDate(0, 0, 1)
+	return !now.Before(expiresAt), nil
+}
+
+//Function to convert a dateFormat date into a lexically sortable date for rich queries
+func toSortableDate(date string) string {
+	parsedDate, err := time.Parse(dateFormat, date)
+	if err != nil {
+		return ""
+	}
+	return parsedDate.Format(sortableDateFormat)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHasCouponExpired(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresOn string
+		timeZone  string
+		now       string
+		want      bool
+		wantErr   bool
+	}{
+		{"before expiry date", "31-12-2019", "", "2019-12-30T12:00:00Z", false, false},
+		{"last second of expiry date", "31-12-2019", "UTC", "2019-12-31T23:59:59Z", false, false},
+		{"day after expiry date", "31-12-2019", "UTC", "2020-01-01T00:00:00Z", true, false},
+		{"issuer zone ahead of UTC still valid", "31-12-2019", "Asia/Kolkata", "2019-12-31T18:29:59Z", false, false},
+		{"issuer zone ahead of UTC expired", "31-12-2019", "Asia/Kolkata", "2019-12-31T18:30:00Z", true, false},
+		{"issuer zone behind UTC still valid", "31-12-2019", "America/New_York", "2020-01-01T04:59:59Z", false, false},
+		{"invalid expiry date", "2019-12-31", "UTC", "2019-12-30T12:00:00Z", false, true},
+		{"unknown time zone", "31-12-2019", "Mars/Olympus_Mons", "2019-12-30T12:00:00Z", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now, err := time.Parse(time.RFC3339, tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := hasCouponExpired(tt.expiresOn, tt.timeZone, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("hasCouponExpired error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("hasCouponExpired(%s, %s, %s) = %v, want %v", tt.expiresOn, tt.timeZone, tt.now, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+)
+
+// A partner contract sets the revenue share of the redemptions at a partner. Contracts
+// are never changed: an amendment is a new version, and the highest version in
+// force at the transaction timestamp applies. Sales transactions record the key and
+// version of the contract used. Partners without any contract fall back to the
+// RevenueSharePercent of the coupon.
+const (
+	partnerContractKeyPrefix = "partnercontract"
+	// Index of the contracts of a partner ordered by version
+	partnerContractIndex = "contract~partner~version"
+
+	shareBasisOriginalPrice   = "ORIGINAL_PRICE"
+	shareBasisDiscountedPrice = "DISCOUNTED_PRICE"
+)
+
+// RevenueShareTier applies RevenueSharePercent to a basis amount of at least
+// FromAmount. The tier with the highest FromAmount not above the basis amount is
+// used for the whole amount.
+type RevenueShareTier struct {
+	FromAmount          decimal.Decimal `json:"fromAmount"`
+	RevenueSharePercent decimal.Decimal `json:"revenueSharePercent"`
+}
+
+type PartnerContract struct {
+	Key                 string             `json:"key"`
+	PartnerKey          string             `json:"partnerKey"`
+	Version             int                `json:"version"`
+	EffectiveFrom       string             `json:"effectiveFrom"`
+	EffectiveTo         string             `json:"effectiveTo,omitempty"`
+	TimeZone            string             `json:"timeZone,omitempty"`
+	ShareBasis          string             `json:"shareBasis"`
+	RevenueSharePercent decimal.Decimal    `json:"revenueSharePercent"`
+	Tiers               []RevenueShareTier `json:"tiers,omitempty"`
+	CreatedDateTime     string             `json:"createdDateTime"`
+}
+
+//Function to add a new version of the contract of a partner
+func (c *CouponChaincode) CreatePartnerContract(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var contract PartnerContract
+	err := parseArgs("createpartnercontract", args, &contract)
+	if err != nil {
+		return errorResponse(err)
+	}
+	partner, found, err := getPartner(stub, contract.PartnerKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !found {
+		return errorResponse(notFoundError(contract.PartnerKey, "Partner %s does not exist", contract.PartnerKey).withField("partnerKey"))
+	}
+	err = validatePartnerContract(&contract)
+	if err != nil {
+		return errorResponse(err)
+	}
+	latestVersion, err := getLatestPartnerContractVersion(stub, partner.Key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	now, err := c.clock(stub).Now()
+	if err != nil {
+		return errorResponse(err)
+	}
+	contract.Key = generateKey(stub, partnerContractKeyPrefix)
+	contract.PartnerKey = partner.Key
+	contract.Version = latestVersion + 1
+	contract.CreatedDateTime = now.Format(dateTimeFormat)
+	contractAsBytes, _ := json.Marshal(contract)
+	writeErr := stub.PutState(contract.Key, contractAsBytes)
+	if writeErr != nil {
+		return errorResponse(fmt.Errorf("Partner contract %s PutState failed: %s", contract.Key, writeErr.Error()))
+	}
+	indexKey, err := getPartnerContractIndexKey(stub, contract)
+	if err != nil {
+		return errorResponse(err)
+	}
+	writeErr = stub.PutState(indexKey, indexEntryValue)
+	if writeErr != nil {
+		return errorResponse(fmt.Errorf("Unable to write contract index for partner %s error : %s", partner.Key, writeErr.Error()))
+	}
+	return shim.Success(contractAsBytes)
+}
+
+//Function to validate the fields of a new contract
+func validatePartnerContract(contract *PartnerContract) error {
+	location, err := loadIssuerLocation(contract.TimeZone)
+	if err != nil {
+		return err
+	}
+	effectiveFrom, err := time.ParseInLocation(dateFormat, contract.EffectiveFrom, location)
+	if err != nil {
+		return invalidArgumentError("effectiveFrom", "Invalid contract effective from date : %s", contract.EffectiveFrom)
+	}
+	if contract.EffectiveTo != "" {
+		effectiveTo, err := time.ParseInLocation(dateFormat, contract.EffectiveTo, location)
+		if err != nil {
+			return invalidArgumentError("effectiveTo", "Invalid contract effective to date : %s", contract.EffectiveTo)
+		}
+		if effectiveTo.Before(effectiveFrom) {
+			return invalidArgumentError("effectiveTo", "Contract effective to date %s is before its effective from date %s", contract.EffectiveTo, contract.EffectiveFrom)
+		}
+	}
+	contract.ShareBasis = strings.ToUpper(contract.ShareBasis)
+	if contract.ShareBasis == "" {
+		contract.ShareBasis = shareBasisOriginalPrice
+	}
+	if contract.ShareBasis != shareBasisOriginalPrice && contract.ShareBasis != shareBasisDiscountedPrice {
+		return invalidArgumentError("shareBasis", "Invalid contract share basis : %s", contract.ShareBasis)
+	}
+	if err = validateRevenueSharePercent(contract.RevenueSharePercent); err != nil {
+		return err
+	}
+	for i, tier := range contract.Tiers {
+		if tier.FromAmount.IsNegative() {
+			return invalidArgumentError("tiers", "Contract tier %d must not start below zero", i)
+		}
+		if i > 0 && !tier.FromAmount.GreaterThan(contract.Tiers[i-1].FromAmount) {
+			return invalidArgumentError("tiers", "Contract tiers must be ordered by increasing from amount")
+		}
+		if err = validateRevenueSharePercent(tier.RevenueSharePercent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateRevenueSharePercent(percent decimal.Decimal) error {
+	if percent.IsNegative() || percent.GreaterThan(decimal.New(100, 0)) {
+		return invalidArgumentError("revenueSharePercent", "Revenue share percent must be between 0 and 100")
+	}
+	return nil
+}
+
+func getPartnerContractIndexKey(stub shim.ChaincodeStubInterface, contract PartnerContract) (string, error) {
+	return stub.CreateCompositeKey(partnerContractIndex, []string{contract.PartnerKey, fmt.Sprintf("%08d", contract.Version), contract.Key})
+}
+
+//Function to get the highest contract version of the partner from the contract
+//index, so a version is never given out twice
+func getLatestPartnerContractVersion(stub shim.ChaincodeStubInterface, partnerKey string) (int, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(partnerContractIndex, []string{partnerKey})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+	latestVersion := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return 0, err
+		}
+		version, err := strconv.Atoi(attributes[1])
+		if err != nil {
+			return 0, fmt.Errorf("Invalid contract index entry %s for partner %s", attributes[1], partnerKey)
+		}
+		if version > latestVersion {
+			latestVersion = version
+		}
+	}
+	return latestVersion, nil
+}
+
+//Function to get the contracts of a partner ordered by version
+func getPartnerContracts(stub shim.ChaincodeStubInterface, partnerKey string) ([]PartnerContract, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(partnerContractIndex, []string{partnerKey})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	var contracts []PartnerContract
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		contractKey := attributes[len(attributes)-1]
+		resultAsBytes, err := stub.GetState(contractKey)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to fetch partner contract %s error : %s", contractKey, err.Error())
+		}
+		if resultAsBytes == nil {
+			continue
+		}
+		var contract PartnerContract
+		err = json.Unmarshal(resultAsBytes, &contract)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid partner contract record %s error : %s", contractKey, err.Error())
+		}
+		contract.Key = contractKey
+		contracts = append(contracts, contract)
+	}
+	return contracts, nil
+}
+
+//Function to get the contract of the partner in force at the time. Nil is returned
+//when no contract of the partner is in force, so the coupon sets the revenue share.
+func getPartnerContractInForce(stub shim.ChaincodeStubInterface, partnerKey string, now time.Time) (*PartnerContract, error) {
+	contracts, err := getPartnerContracts(stub, partnerKey)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(contracts) - 1; i >= 0; i-- {
+		inForce, err := isContractInForce(contracts[i], now)
+		if err != nil {
+			return nil, err
+		}
+		if inForce {
+			return &contracts[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func isContractInForce(contract PartnerContract, now time.Time) (bool, error) {
+	location, err := loadIssuerLocation(contract.TimeZone)
+	if err != nil {
+		return false, err
+	}
+	effectiveFrom, err := time.ParseInLocation(dateFormat, contract.EffectiveFrom, location)
+	if err != nil {
+		return false, fmt.Errorf("Invalid contract effective from date : %s", contract.EffectiveFrom)
+	}
+	if now.Before(effectiveFrom) {
+		return false, nil
+	}
+	if contract.EffectiveTo == "" {
+		return true, nil
+	}
+	hasEnded, err := hasCouponExpired(contract.EffectiveTo, contract.TimeZone, now)
+	if err != nil {
+		return false, err
+	}
+	return !hasEnded, nil
+}
+
+//Function to get the basis amount and the revenue share percent of the contract
+func (contract PartnerContract) revenueShare(assetOriginalPrice decimal.Decimal, discountAmount decimal.Decimal) (decimal.Decimal, decimal.Decimal) {
+	basisAmount := assetOriginalPrice
+	if contract.ShareBasis == shareBasisDiscountedPrice {
+		basisAmount = assetOriginalPrice.Sub(discountAmount)
+	}
+	percent := contract.RevenueSharePercent
+	for _, tier := range contract.Tiers {
+		if basisAmount.LessThan(tier.FromAmount) {
+			break
+		}
+		percent = tier.RevenueSharePercent
+	}
+	return basisAmount, percent
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestPartnerContractRevenueShare(t *testing.T) {
+	amount := decimal.RequireFromString
+	tiers := []RevenueShareTier{
+		{FromAmount: amount("1000"), RevenueSharePercent: amount("7")},
+		{FromAmount: amount("5000"), RevenueSharePercent: amount("10")},
+	}
+	tests := []struct {
+		name        string
+		shareBasis  string
+		price       string
+		discount    string
+		wantBasis   string
+		wantPercent string
+	}{
+		{"below the first tier", shareBasisOriginalPrice, "999.99", "0", "999.99", "5"},
+		{"at the first tier", shareBasisOriginalPrice, "1000", "0", "1000", "7"},
+		{"between the tiers", shareBasisOriginalPrice, "4999.99", "100", "4999.99", "7"},
+		{"above the last tier", shareBasisOriginalPrice, "6000", "100", "6000", "10"},
+		{"discounted price drops below a tier", shareBasisDiscountedPrice, "1050", "100", "950", "5"},
+		{"discounted price reaches a tier", shareBasisDiscountedPrice, "5100", "100", "5000", "10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contract := PartnerContract{ShareBasis: tt.shareBasis, RevenueSharePercent: amount("5"), Tiers: tiers}
+			basis, percent := contract.revenueShare(amount(tt.price), amount(tt.discount))
+			if !basis.Equal(amount(tt.wantBasis)) {
+				t.Errorf("revenueShare basis = %s, want %s", basis, tt.wantBasis)
+			}
+			if !percent.Equal(amount(tt.wantPercent)) {
+				t.Errorf("revenueShare percent = %s, want %s", percent, tt.wantPercent)
+			}
+		})
+	}
+}
+
+func TestPartnerContractRevenueShareWithoutTiers(t *testing.T) {
+	contract := PartnerContract{ShareBasis: shareBasisOriginalPrice, RevenueSharePercent: decimal.New(5, 0)}
+	_, percent := contract.revenueShare(decimal.New(1000000, 0), decimal.Zero)
+	if !percent.Equal(decimal.New(5, 0)) {
+		t.Errorf("revenueShare percent = %s, want 5", percent)
+	}
+}
+
+func TestRedeemWithoutContractInForceUsesCouponShare(t *testing.T) {
+	ledger := newTestLedger(t, "2019-06-15T10:00:00Z")
+	customerKey := "customer:alice"
+	partner := ledger.seedPartnerAndCustomer(customerKey)
+	ledger.mustInvoke(testAdmin, "createpartnercontract", map[string]string{
+		"partnerKey":          partner.PartnerKey,
+		"effectiveFrom":       "01-07-2019",
+		"revenueSharePercent": "5",
+	}, nil)
+	couponKey := ledger.createCoupon(map[string]string{
+		"name":                "Summer Sale",
+		"expiresOn":           "31-12-2019",
+		"discountAmount":      "10",
+		"revenueSharePercent": "10",
+		"customerKey":         customerKey,
+	})
+	sale := ledger.mustRedeem(partner, couponKey, customerKey, "100").SalesTransaction
+	if sale.ContractKey != "" {
+		t.Errorf("sale used contract %s before it came into force", sale.ContractKey)
+	}
+	assertAmount(t, "revenue share percent", sale.RevenueSharePercent, "10")
+	assertAmount(t, "revenue share amount", sale.RevenueShareAmount, "10")
+}
@@ -9,29 +9,60 @@ import (
     "bytes"
     "github.com/hyperledger/fabric/core/chaincode/shim"
      sc "github.com/hyperledger/fabric/protos/peer"
+    "github.com/shopspring/decimal"
 )
 
 type CouponChaincode struct {
-
+	// clockFactory overrides the transaction timestamp clock, e.g. for tests
+	clockFactory        ClockFactory
 }
 
 type QueryRecord struct{
 	RecordType 			string 					 `json:"recordType"`
+	PageSize 			int32 					 `json:"pageSize,omitempty"`
+	Bookmark 			string 					 `json:"bookmark,omitempty"`
 }
 
 type QueryKey struct{
 	Key 				string 					 `json:"key"`
 }
 
+type CustomerCouponQuery struct{
+	Key 				string 					 `json:"key"`
+	Status 				string 					 `json:"status,omitempty"`
+	PageSize 			int32 					 `json:"pageSize,omitempty"`
+	Bookmark 			string 					 `json:"bookmark,omitempty"`
+}
+
 type Coupon struct {	
     Key                 string           		 `json:"key"`
     Name                string              	 `json:"name"`
     CreatedDateTime     string            		 `json:"createdDateTime"`
     ExpiresOn           string           		 `json:"expiresOn"`   	
-    DiscountAmount      float64              	 `json:"discountAmount,string"`
-    RevenueSharePercent float64              	 `json:"revenueSharePercent,string"`
+    ExpiryDate          string           		 `json:"expiryDate,omitempty"`
+    DiscountType        string               	 `json:"discountType,omitempty"`
+    DiscountAmount      decimal.Decimal      	 `json:"discountAmount"`
+    DiscountPercent     decimal.Decimal      	 `json:"discountPercent"`
+    MaxDiscountAmount   decimal.Decimal      	 `json:"maxDiscountAmount"`
+    MinimumPurchaseAmount decimal.Decimal    	 `json:"minimumPurchaseAmount"`
+    ExcessDiscountPolicy string              	 `json:"excessDiscountPolicy,omitempty"`
+    RevenueSharePercent decimal.Decimal      	 `json:"revenueSharePercent"`
+    Currency            string               	 `json:"currency,omitempty"`
+    MaxUses             int                  	 `json:"maxUses,omitempty"`
+    UsesCount           int                  	 `json:"usesCount"`
+    RemainingBalance    decimal.Decimal      	 `json:"remainingBalance"`
+    SalesTransactionKeys []string            	 `json:"salesTransactionKeys,omitempty"`
     Status              string               	 `json:"status"` 
     CustomerKey         string               	 `json:"customerKey"` 
+    CampaignKey         string               	 `json:"campaignKey,omitempty"`
+    EligiblePartnerKeys []string             	 `json:"eligiblePartnerKeys,omitempty"`
+    EligiblePartnerGroups []string           	 `json:"eligiblePartnerGroups,omitempty"`
+    EligiblePartnerCategories []string       	 `json:"eligiblePartnerCategories,omitempty"`
+    AllowedRegions      []Region             	 `json:"allowedRegions,omitempty"`
+    Transferable        bool                 	 `json:"transferable,omitempty"`
+    TransferredFrom     string               	 `json:"transferredFrom,omitempty"`
+    TransferredDateTime string               	 `json:"transferredDateTime,omitempty"`
+    TimeZone            string               	 `json:"timeZone,omitempty"`
 }
 
 type CouponResponse struct {
@@ -39,16 +70,26 @@ type CouponResponse struct {
 	Coupon 				Coupon 					  `json:"record"`
 }
 
+// Customer Name and Email are never written to the public state, they are only
+// filled in from the private data collection for callers allowed to read it.
 type Customer struct {
     Key                 string               	 `json:"key"`
-    Name                string               	 `json:"name"`
-    Email               string              	 `json:"email"`
+    Name                string               	 `json:"name,omitempty"`
+    Email               string              	 `json:"email,omitempty"`
+    PIIHash             string              	 `json:"piiHash,omitempty"`
+    Status              string              	 `json:"status,omitempty"`
+    Version             int                 	 `json:"version"`
+    CreatedDateTime     string              	 `json:"createdDateTime,omitempty"`
+    UpdatedDateTime     string              	 `json:"updatedDateTime,omitempty"`
 }
 
 type Partner struct {
     Key                 string                   `json:"key"`
     Name                string                   `json:"name"`
     AddressKey          string                   `json:"addressKey"`
+    Group               string                   `json:"group,omitempty"`
+    Category            string                   `json:"category,omitempty"`
+    Status              string                   `json:"status,omitempty"`
 }
 
 type Address struct {
@@ -62,20 +103,31 @@ type Address struct {
 type ValidateCouponRequest struct {
     CouponKey          	string                	 `json:"couponKey"`
     CustomerKey         string               	 `json:"customerKey"`
+    PartnerKey          string               	 `json:"partnerKey,omitempty"`
+    AssetOriginalPrice  *decimal.Decimal     	 `json:"assetOriginalPrice,omitempty"`
 }
 
 type ValidateCouponResponse struct {
 	IsValid          	bool                	 `json:"isValid"`
+	Reason				string			    	 `json:"reason,omitempty"`
 	Message				string			    	 `json:"message"`
+	DiscountAmount		*decimal.Decimal	    	 `json:"discountAmount,omitempty"`
+	Status				string			    	 `json:"status,omitempty"`
+	RemainingUses		int				    	 `json:"remainingUses,omitempty"`
+	RemainingBalance	*decimal.Decimal	    	 `json:"remainingBalance,omitempty"`
 }
 
 type RedeemCouponRequest struct { 
-    AssetOriginalPrice  float64             	 `json:"assetOriginalPrice,string"`
+    AssetOriginalPrice  decimal.Decimal     	 `json:"assetOriginalPrice"`
     CouponKey           string              	 `json:"couponKey"`
+    CustomerKey         string              	 `json:"customerKey"`
     PartnerKey          string               	 `json:"partnerKey"`
+    RequestID           string               	 `json:"requestId,omitempty"`
 }
 
 type RedeemCouponResponse struct { 
+    Message             string               	`json:"message"`
+    CouponStatus        string               	`json:"couponStatus"`
     SalesTransaction 	SalesTransaction 	 	`json:"salesTransaction"`
 }
 
@@ -88,10 +140,24 @@ type SalesTransaction struct {
     Key                 string                	 `json:"key,omitempty"`
     PartnerKey          string                	 `json:"partnerKey"`
     CouponKey           string               	 `json:"couponKey"`
-    AssetOriginalPrice  float64              	 `json:"assetOriginalPrice,string"`
-    SalesAmount         float64              	 `json:"salesAmount,string"`
-    RevenueShareAmount  float64              	 `json:"revenueShareAmount,string"`
-    SettlementAmount    float64              	 `json:"settlementAmount,string"`
+    UseNumber           int                  	 `json:"useNumber,omitempty"`
+    AssetOriginalPrice  decimal.Decimal      	 `json:"assetOriginalPrice"`
+    DiscountAmount      decimal.Decimal      	 `json:"discountAmount"`
+    SalesAmount         decimal.Decimal      	 `json:"salesAmount"`
+    RevenueShareAmount  decimal.Decimal      	 `json:"revenueShareAmount"`
+    RevenueSharePercent decimal.Decimal      	 `json:"revenueSharePercent"`
+    ShareBasis          string               	 `json:"shareBasis,omitempty"`
+    ContractKey         string               	 `json:"contractKey,omitempty"`
+    ContractVersion     int                  	 `json:"contractVersion,omitempty"`
+    SettlementAmount    decimal.Decimal      	 `json:"settlementAmount"`
+    SalesAmountSortKey  string               	 `json:"salesAmountSortKey,omitempty"`
+    SettlementAmountSortKey string           	 `json:"settlementAmountSortKey,omitempty"`
+    Currency            string                	 `json:"currency,omitempty"`
+    CreatedDateTime     string                	 `json:"createdDateTime,omitempty"`
+    SettlementBatchKey  string                	 `json:"settlementBatchKey,omitempty"`
+    ReversalOf          string                	 `json:"reversalOf,omitempty"`
+    RefundedAmount      decimal.Decimal       	 `json:"refundedAmount"`
+    ReversalKeys        []string              	 `json:"reversalKeys,omitempty"`
 }
 
 var (
@@ -102,14 +168,8 @@ var (
 const (
 	couponKeyPrefix = "coupon"
 	salesTransactionKeyPrefix = "salestransaction"
-	couponRangeStartKey = "couponrangestartkey"
-	couponRangeEndKey = "couponrangeendkey"
-	customerRangeStartKey = "customerrangestartkey"
-	customerRangeEndKey = "customerrangeendkey"
-	partnerRangeStartKey = "partnerrangestartkey"
-    partnerRangeEndKey = "partnerrangeendkey"
-    salesTransactionRangeStartKey = "salesTransactionrangestartkey"
-    salesTransactionRangeEndKey = "salesTransactionrangendkey"
+	customerKeyPrefix = "customer"
+	partnerKeyPrefix = "partner"
 	dateFormat = "02-01-2006"
 	couponStatusIssued = "ISSUED"
 	couponStatusRedeemed = "REDEEMED"
@@ -118,16 +178,54 @@ const (
 // Init is called during the smart contract instantiation .
 func (t *CouponChaincode) Init(stub shim.ChaincodeStubInterface) sc.Response  {
     //initiating the ledger 
-    t.initCustomers(stub)
-    t.initPartners(stub)
-    t.initAddresses(stub) 
-	t.initRangeKeys(stub)
+	emailIndexSecret, err := t.initEmailIndexSecret(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = t.initCustomers(stub, emailIndexSecret)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = t.initAddresses(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = t.initPartners(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = t.initAccessPolicy(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
 	return shim.Success(nil)
 }
 
 // Invoke is called to update or query the ledger in a  transaction proposal.
 func (c *CouponChaincode) Invoke(stub shim.ChaincodeStubInterface) sc.Response {
     fnc, args := stub.GetFunctionAndParameters()
+    transaction := newTxStub(stub)
+    caller, err := getCallerIdentity(stub)
+    if err != nil {
+        return errorResponse(err)
+    }
+    err = checkPermission(stub, strings.ToLower(fnc), caller)
+    if err != nil {
+        return errorResponse(err)
+    }
+    transaction.caller = caller
+    response := c.invokeFunction(transaction, fnc, args)
+    if response.Status == shim.OK {
+        err = emitEvents(transaction)
+        if err != nil {
+            return errorResponse(err)
+        }
+    }
+    return response
+}
+
+//Function to route the call to the handler function
+func (c *CouponChaincode) invokeFunction(stub shim.ChaincodeStubInterface, fnc string, args []string) sc.Response {
     // Route to the appropriate handler function to interact with the ledger appropriately
     switch(strings.ToLower(fnc)) {
     case "createcoupon" :
@@ -148,127 +246,358 @@ func (c *CouponChaincode) Invoke(stub shim.ChaincodeStubInterface) sc.Response {
 		return c.QueryHistoryByKey(stub, args)
 	case "querycouponsbycustomer" :
 		return c.QueryCouponsByCustomer(stub, args)
+	case "querycustomercoupons" :
+		return c.QueryCustomerCoupons(stub, args)
+	case "setcurrencyconfig" :
+		return c.SetCurrencyConfig(stub, args)
+	case "queryrecords" :
+		return c.QueryRecords(stub, args)
+	case "createcustomer" :
+		return c.CreateCustomer(stub, args)
+	case "updatecustomer" :
+		return c.UpdateCustomer(stub, args)
+	case "deactivatecustomer" :
+		return c.DeactivateCustomer(stub, args)
+	case "getcustomerbyemail" :
+		return c.GetCustomerByEmail(stub, args)
+	case "registerpartner" :
+		return c.RegisterPartner(stub, args)
+	case "updatepartner" :
+		return c.UpdatePartner(stub, args)
+	case "suspendpartner" :
+		return c.SuspendPartner(stub, args)
+	case "createaddress" :
+		return c.CreateAddress(stub, args)
+	case "updateaddress" :
+		return c.UpdateAddress(stub, args)
+	case "rebuildcouponindex" :
+		return c.RebuildCouponIndex(stub, args)
+	case "setaccesspolicy" :
+		return c.SetAccessPolicy(stub, args)
+	case "transfercoupon" :
+		return c.TransferCoupon(stub, args)
+	case "createcouponsbatch" :
+		return c.CreateCouponsBatch(stub, args)
+	case "createcampaign" :
+		return c.CreateCampaign(stub, args)
+	case "createsettlementbatch" :
+		return c.CreateSettlementBatch(stub, args)
+	case "updatesettlementbatchstatus" :
+		return c.UpdateSettlementBatchStatus(stub, args)
+	case "reverseredemption" :
+		return c.ReverseRedemption(stub, args)
+	case "createpartnercontract" :
+		return c.CreatePartnerContract(stub, args)
+	case "setemailindexsecret" :
+		return c.SetEmailIndexSecret(stub, args)
+	case "rebuildsettlementindex" :
+		return c.RebuildSettlementIndex(stub, args)
     default: 
-        return shim.Error(fmt.Sprintf("Invalid ChainCode Function : %s", fnc))
+        return errorResponse(invalidArgumentError("function", "Invalid ChainCode Function : %s", fnc))
     }
 }
 
 //Function to get Record by Key
 func (c *CouponChaincode) QueryByKey(stub shim.ChaincodeStubInterface, args []string) sc.Response {
 	var queryKey QueryKey
-	json.Unmarshal([]byte (args[0]), &queryKey)
+	err := parseArgs("querybykey", args, &queryKey)
+	if err != nil {
+		return errorResponse(err)
+	}
 	key := strings.ToLower(queryKey.Key)
 	resultAsBytes , err := stub.GetState(key)
-	if err != nil || resultAsBytes == nil {
-		return shim.Error(fmt.Sprintf("QueryByKey failed for Key : %s error : %s",key ,err.Error()))   
+	if err != nil {
+		return errorResponse(fmt.Errorf("QueryByKey failed for Key : %s error : %s",key ,err.Error()))   
 	} 
+	if resultAsBytes == nil {
+		return errorResponse(notFoundError(key, "QueryByKey failed for Key : %s error : record not found",key))
+	}
+	if strings.HasPrefix(key, customerKeyPrefix + ":") {
+		var customer Customer
+		err = json.Unmarshal(resultAsBytes, &customer)
+		if err != nil {
+			return errorResponse(fmt.Errorf("Invalid customer record %s error : %s", key, err.Error()))
+		}
+		customer.Key = key
+		customer, err = withCustomerPII(stub, customer)
+		if err != nil {
+			return errorResponse(err)
+		}
+		resultAsBytes, _ = json.Marshal(customer)
+	}
 	return shim.Success(resultAsBytes)
 }
 
 //Get Result by Query
 func (c *CouponChaincode) QueryByRange(stub shim.ChaincodeStubInterface, args []string) sc.Response {
-	var startKeyAsBytes []byte
-	var endKeyAsBytes []byte
 	var record QueryRecord
-	json.Unmarshal([]byte (args[0]), &record)
-    switch(strings.ToLower(record.RecordType)) {
-    case "coupon" :
-		startKeyAsBytes, _  = stub.GetState(couponRangeStartKey)
-		endKeyAsBytes, _    = stub.GetState(couponRangeEndKey)
-	case "customer" : 
-		startKeyAsBytes, _  = stub.GetState(customerRangeStartKey)
-		endKeyAsBytes, _    = stub.GetState(customerRangeEndKey)
-    case "salestransaction" :
-        startKeyAsBytes, _  = stub.GetState(salesTransactionRangeStartKey)
-		endKeyAsBytes, _    = stub.GetState(salesTransactionRangeEndKey)
-	case "partner" :
-        startKeyAsBytes, _  = stub.GetState(partnerRangeStartKey)
-		endKeyAsBytes, _    = stub.GetState(partnerRangeEndKey)
-    default: 
-		return shim.Error(fmt.Sprintf("Invalid Entity Type : %s  ARGS: %s", record.RecordType, args[0]))
+	err := parseArgs("querybyrange", args, &record)
+	if err != nil {
+		return errorResponse(err)
 	}
-	startRangeKey := string(startKeyAsBytes)
-	endRangeKey := string(endKeyAsBytes)
-	outboundEndKey  := strings.Split(endRangeKey, ":")
-	outboundEndKeyNumber , _ :=  strconv.Atoi(outboundEndKey[1])
-	resultByte, err := getStatebyRangeResult(stub,startRangeKey, getKeyByRecordType(outboundEndKey[0], outboundEndKeyNumber + 1))
+	recordType := strings.ToLower(record.RecordType)
+	switch(recordType) {
+	case couponKeyPrefix, customerKeyPrefix, salesTransactionKeyPrefix, partnerKeyPrefix, addressKeyPrefix, campaignKeyPrefix, settlementBatchKeyPrefix, partnerContractKeyPrefix :
+	default: 
+		return errorResponse(invalidArgumentError("recordType", "Invalid Entity Type : %s", record.RecordType))
+	}
+	startRangeKey, endRangeKey := getRecordTypeRange(recordType)
+	if record.PageSize != 0 || record.Bookmark != "" {
+		resultByte, err := getStatebyRangeResultWithPagination(stub, startRangeKey, endRangeKey, record.PageSize, record.Bookmark)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return shim.Success(resultByte)
+	}
+	resultByte, err := getStatebyRangeResult(stub, startRangeKey, endRangeKey)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 	return shim.Success(resultByte)
 }
 
 // Function to create record
 func (c *CouponChaincode) CreateCoupon(stub shim.ChaincodeStubInterface, args []string) sc.Response {
-    
-    resultAsBytes, err := stub.GetState(couponRangeEndKey);
-    if err != nil {
-        return shim.Error(fmt.Sprintf("CouponRangeEndKey %s GetState failed : %s", couponRangeEndKey , err.Error()))
+	var coupon Coupon
+	err := parseArgs("createcoupon", args, &coupon)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = validateNewCoupon(&coupon)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkCouponCustomer(stub, coupon)
+	if err != nil {
+		return errorResponse(err)
+	}
+	now, err := c.clock(stub).Now()
+	if err != nil {
+		return errorResponse(err)
+	}
+	issuance := newCampaignIssuance(stub, now)
+	err = issuance.add(&coupon)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = putNewCoupon(stub, &coupon)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = issuance.save()
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success([]byte (fmt.Sprintf("%s created successfully", coupon.Key)))
+}
+
+//Function to normalize and validate the fields of a new coupon
+func validateNewCoupon(coupon *Coupon) error {
+	coupon.CustomerKey = strings.ToLower(coupon.CustomerKey)
+	coupon.TransferredFrom = ""
+	coupon.TransferredDateTime = ""
+	normalizePartnerEligibility(coupon)
+	coupon.Status = couponStatusIssued
+	err := validateDiscount(coupon)
+	if err != nil {
+		return err
+	}
+	err = validateRevenueSharePercent(coupon.RevenueSharePercent)
+	if err != nil {
+		return err
+	}
+	_, err = loadIssuerLocation(coupon.TimeZone)
+	if err != nil {
+		return err
 	}
-	newRecordKey , keyNumber := generateKey(string(resultAsBytes))
-    writeErr := stub.PutState(newRecordKey, []byte (args[0]))
-    if writeErr != nil {
-        return shim.Error(fmt.Sprintf("Coupon %s PutState failed: %s", newRecordKey, writeErr.Error()))
+	err = normalizeRegions(coupon)
+	if err != nil {
+		return err
+	}
+	err = validateCouponUsage(coupon)
+	if err != nil {
+		return err
+	}
+	coupon.ExpiryDate = toSortableDate(coupon.ExpiresOn)
+	if coupon.ExpiryDate == "" {
+		return invalidArgumentError("expiresOn", "Invalid Coupon expiry date : %s", coupon.ExpiresOn)
 	}
-	writeErr = stub.PutState(couponRangeEndKey, []byte(getKeyByRecordType(couponKeyPrefix,keyNumber)))
+	return nil
+}
+
+//Function to check that the coupon is issued to an existing active customer
+func checkCouponCustomer(stub shim.ChaincodeStubInterface, coupon Coupon) error {
+	customer, found, err := getCustomer(stub, coupon.CustomerKey)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return notFoundError(coupon.CustomerKey, "Customer %s does not exist", coupon.CustomerKey).withField("customerKey")
+	}
+	if customer.Status == customerStatusInactive {
+		return conflictError(customer.Key, "Customer %s is inactive", customer.Key).withField("customerKey")
+	}
+	return nil
+}
+
+//Function to store a new coupon under a generated key and index it by customer
+func putNewCoupon(stub shim.ChaincodeStubInterface, coupon *Coupon) error {
+	coupon.Key = generateKey(stub, couponKeyPrefix)
+	couponAsBytes, _ := json.Marshal(coupon)
+	writeErr := stub.PutState(coupon.Key, couponAsBytes)
 	if writeErr != nil {
-	   return shim.Error(fmt.Sprintf("CouponRangeEndKey %s PutState failed : %s", couponRangeEndKey, writeErr.Error()))
-    }
-	return shim.Success([]byte (fmt.Sprintf("%s created successfully", newRecordKey)))
+		return fmt.Errorf("Coupon %s PutState failed: %s", coupon.Key, writeErr.Error())
+	}
+	err := putCustomerCouponIndex(stub, *coupon)
+	if err != nil {
+		return err
+	}
+	if coupon.CampaignKey != "" {
+		err = putCampaignCustomerIndex(stub, *coupon)
+		if err != nil {
+			return err
+		}
+	}
+	addEvent(stub, RecordEvent{
+		Type: eventCouponCreated,
+		RecordKey: coupon.Key,
+		NewStatus: coupon.Status,
+		Amounts: map[string]decimal.Decimal{ "discountAmount": coupon.DiscountAmount },
+	})
+	return nil
 }
 
 // Function to create record
 func (c *CouponChaincode) CreateSalesTransaction(stub shim.ChaincodeStubInterface, args []string) sc.Response {
-     resultAsBytes, err := stub.GetState(salesTransactionRangeEndKey);
-    if err != nil {
-        return shim.Error(fmt.Sprintf("SalesTransactionRangeEndKey %s GetState failed : %s", salesTransactionRangeEndKey , err.Error()))
+	var salesTransaction SalesTransaction
+	err := parseArgs("createsalestransaction", args, &salesTransaction)
+	if err != nil {
+		return errorResponse(err)
 	}
-    newRecordKey , keyNumber:= generateKey(string(resultAsBytes))
-    writeErr := stub.PutState(newRecordKey, []byte (args[0]))
-    if writeErr != nil {
-        return shim.Error(fmt.Sprintf("SalesTransaction %s PutState failed: %s", newRecordKey, writeErr.Error()))
+	err = putNewSalesTransaction(stub, &salesTransaction)
+	if err != nil {
+		return errorResponse(err)
 	}
-	writeErr = stub.PutState(salesTransactionRangeEndKey, []byte(getKeyByRecordType(salesTransactionKeyPrefix , keyNumber)))
+	return shim.Success([]byte (fmt.Sprintf("%s created successfully", salesTransaction.Key)))
+}
+
+//Function to store a new sales transaction under a generated key
+func putNewSalesTransaction(stub shim.ChaincodeStubInterface, salesTransaction *SalesTransaction) error {
+	salesTransaction.Key = generateKey(stub, salesTransactionKeyPrefix)
+	salesTransaction.PartnerKey = strings.ToLower(salesTransaction.PartnerKey)
+	salesTransaction.SettlementBatchKey = ""
+	salesTransaction.RefundedAmount = decimal.Zero
+	salesTransaction.ReversalKeys = nil
+	err := setAmountSortKeys(salesTransaction)
+	if err != nil {
+		return err
+	}
+	salesTransactionAsBytes, _ := json.Marshal(salesTransaction)
+	writeErr := stub.PutState(salesTransaction.Key, salesTransactionAsBytes)
 	if writeErr != nil {
-	   return shim.Error(fmt.Sprintf("SalesTransactionRangeEndKey %s PutState failed : %s", salesTransactionRangeEndKey, writeErr.Error()))
-    }
-	return shim.Success([]byte (fmt.Sprintf("%s created successfully", newRecordKey)))
+		return fmt.Errorf("SalesTransaction %s PutState failed: %s", salesTransaction.Key, writeErr.Error())
+	}
+	if salesTransaction.PartnerKey != "" {
+		err := putUnsettledSalesTransactionIndex(stub, *salesTransaction)
+		if err != nil {
+			return err
+		}
+	}
+	addEvent(stub, RecordEvent{
+		Type: eventSalesTransactionCreated,
+		RecordKey: salesTransaction.Key,
+		Amounts: salesTransactionAmounts(*salesTransaction),
+	})
+	return nil
 }
 
 //Function to delete record
 func (c *CouponChaincode) DeleteRecord(stub shim.ChaincodeStubInterface, args []string) sc.Response {
 	var queryKey QueryKey
-	json.Unmarshal([]byte (args[0]), &queryKey)
+	err := parseArgs("deleterecord", args, &queryKey)
+	if err != nil {
+		return errorResponse(err)
+	}
 	deleteKey := strings.ToLower(queryKey.Key)
-	keyValue := strings.Split(deleteKey, ":")
-	keyTextValue := keyValue[0]
-	keyNumberValue, _ := strconv.Atoi(keyValue[1])
-	// Delete the key
-	delErr := stub.DelState(deleteKey)
-	if delErr != nil {
-		return shim.Error(fmt.Sprintf("Failed to delete record %s error: %s", args[0], delErr.Error()))
-	} else {
-		isRecordTypeFound, key :=  getRecordRangeEndKey(keyTextValue)
-		if isRecordTypeFound {
-			//If the deleted record is the last record, then update the end range keys
-		    latestRecordRangeEndKey := key
-			resultAsBytes, err := stub.GetState(latestRecordRangeEndKey);
+	deletedEvent := RecordEvent{ Type: eventRecordDeleted, RecordKey: deleteKey }
+	switch(strings.Split(deleteKey, ":")[0]) {
+	case couponKeyPrefix :
+		//Remove the coupon from the customer index and its campaign before deleting it
+		coupon, found, err := getCoupon(stub, deleteKey)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if found {
+			deletedEvent.OldStatus = coupon.Status
+			err = delCustomerCouponIndex(stub, coupon)
+			if err != nil {
+				return errorResponse(err)
+			}
+			err = releaseCampaignCoupon(stub, coupon)
+			if err != nil {
+				return errorResponse(err)
+			}
+		}
+	case customerKeyPrefix :
+		//Remove the PII and email index of the customer from the private data collection
+		err := deleteCustomerPII(stub, deleteKey)
+		if err != nil {
+			return errorResponse(err)
+		}
+	case partnerKeyPrefix :
+		partner, found, err := getPartner(stub, deleteKey)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if found {
+			err = delAddressPartnerIndex(stub, partner)
 			if err != nil {
-				return shim.Error(fmt.Sprintf("Failed to get Range End Keys for %s error: %s", keyTextValue, err.Error()))
+				return errorResponse(err)
 			}
-			latestRecordRangeEndKeyValue := string(resultAsBytes)
-			value := strings.Split(latestRecordRangeEndKeyValue, ":")
-			latestRecordRangeEndKeyNumberValue, err := strconv.Atoi(value[1])
-			if keyNumberValue == latestRecordRangeEndKeyNumberValue {
-				latestRecordRangeEndKeyNumberValue -= 1 
+		}
+	case addressKeyPrefix :
+		//Addresses still used by a partner must not be deleted
+		isReferenced, err := isAddressReferenced(stub, deleteKey)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if isReferenced {
+			return errorResponse(conflictError(deleteKey, "Address %s is referenced by a partner and can not be deleted", deleteKey))
+		}
+	case salesTransactionKeyPrefix :
+		//Settled sales transactions are part of the totals of their settlement batch
+		salesTransaction, found, err := getSalesTransaction(stub, deleteKey)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if found {
+			if salesTransaction.SettlementBatchKey != "" {
+				return errorResponse(conflictError(deleteKey, "Sales transaction %s is settled in %s and can not be deleted", deleteKey, salesTransaction.SettlementBatchKey))
 			}
-			revisedEndRangeKey := getKeyByRecordType(keyTextValue , latestRecordRangeEndKeyNumberValue)
-			writeErr := stub.PutState(key, []byte(revisedEndRangeKey))
-			if writeErr != nil {
-				return shim.Error(fmt.Sprintf("Unable to write %s end key to the ledger. error : %s" , revisedEndRangeKey, writeErr.Error()))
+			err = delUnsettledSalesTransactionIndex(stub, salesTransaction)
+			if err != nil {
+				return errorResponse(err)
 			}
-		} 
+		}
+	case partnerContractKeyPrefix :
+		//Contracts are never modified, sales transactions refer to them by key and version
+		return errorResponse(conflictError(deleteKey, "Partner contract %s can not be deleted", deleteKey))
+	case campaignKeyPrefix :
+		//Campaigns with issued coupons keep the totals of those coupons
+		hasCoupons, err := hasCampaignCoupons(stub, deleteKey)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if hasCoupons {
+			return errorResponse(conflictError(deleteKey, "Campaign %s has issued coupons and can not be deleted", deleteKey))
+		}
 	}
+	// Delete the key
+	delErr := stub.DelState(deleteKey)
+	if delErr != nil {
+		return errorResponse(fmt.Errorf("Failed to delete record %s error: %s", deleteKey, delErr.Error()))
+	}
+	addEvent(stub, deletedEvent)
 	return shim.Success([]byte ("Deleted record "+ deleteKey))
 }
 
@@ -276,117 +605,208 @@ func (c *CouponChaincode) DeleteRecord(stub shim.ChaincodeStubInterface, args []
 func (c *CouponChaincode) ValidateCoupon(stub shim.ChaincodeStubInterface,args []string) sc.Response {
 	
 	var  validateCouponRequest ValidateCouponRequest
-	var  validateCouponResponse ValidateCouponResponse
-	json.Unmarshal([]byte(args[0]), &validateCouponRequest)
-	resultAsBytes, err := stub.GetState(validateCouponRequest.CouponKey);
-	if err != nil || resultAsBytes == nil{
-		return shim.Error(fmt.Sprintf("Unable to fetch coupon %s error : %s" , validateCouponRequest.CouponKey , err.Error()))
+	err := parseArgs("validatecoupon", args, &validateCouponRequest)
+	if err != nil {
+		return errorResponse(err)
 	}
-	var coupon Coupon
-	json.Unmarshal(resultAsBytes, &coupon)
-	if coupon.CustomerKey != validateCouponRequest.CustomerKey {
-		validateCouponResponse.IsValid = false
-		validateCouponResponse.Message = fmt.Sprintf("Invalid Coupon : %s for Customer : %s" ,  validateCouponRequest.CouponKey , validateCouponRequest.CustomerKey)
-		result , _ := json.Marshal(validateCouponResponse)
-		return shim.Success(result)
-	}
-	if coupon.Status != couponStatusIssued {
-		validateCouponResponse.IsValid = false
-		validateCouponResponse.Message = fmt.Sprintf("Invalid Coupon status : %s", coupon.Status)
-		result, _ := json.Marshal(validateCouponResponse)
-		return shim.Success(result)
-	}
-	expiryDate, err := time.Parse(dateFormat, coupon.ExpiresOn)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("Invalid Coupon expiry date : %s", expiryDate))
-	}
-	if hasCouponExpired(expiryDate) {
-		validateCouponResponse.IsValid = false
-		validateCouponResponse.Message = fmt.Sprintf("Coupon %s has expired!!! ",  validateCouponRequest.CouponKey )
-		result, _ := json.Marshal(validateCouponResponse)
-		return shim.Success(result)
-	}
-	validateCouponResponse.IsValid = true
-	validateCouponResponse.Message = fmt.Sprintf("Valid Coupon %s!!!", validateCouponRequest.CouponKey)
-	result, _ := json.Marshal(validateCouponResponse)
+	eligibility, err := c.checkCouponEligibility(stub, eligibilityRequest{
+		CouponKey: validateCouponRequest.CouponKey,
+		CustomerKey: validateCouponRequest.CustomerKey,
+		PartnerKey: validateCouponRequest.PartnerKey,
+		AssetOriginalPrice: validateCouponRequest.AssetOriginalPrice,
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
+	result, _ := json.Marshal(eligibility.Response)
 	return shim.Success(result)
 }
 
+//Function to redeem coupon. The full eligibility check runs in the same transaction
+//and an ineligible coupon is rejected with the validation response as error message.
 func (c *CouponChaincode) RedeemCoupon(stub shim.ChaincodeStubInterface, args []string) sc.Response {
 	
 	var redeemCouponRequest RedeemCouponRequest
-	json.Unmarshal([]byte(args[0]), &redeemCouponRequest)
-	//Get Coupon Information based on CouponKey
-	resultAsBytes, err := stub.GetState(redeemCouponRequest.CouponKey);
+	err := parseArgs("redeemcoupon", args, &redeemCouponRequest)
+	if err != nil {
+		return errorResponse(err)
+	}
+	caller := getCaller(stub)
+	if caller.Role == rolePartner && strings.ToLower(redeemCouponRequest.PartnerKey) != caller.PartnerKey {
+		return errorResponse(forbiddenError(redeemCouponRequest.PartnerKey, "Access denied : partner %s may not redeem as %s", caller.PartnerKey, redeemCouponRequest.PartnerKey))
+	}
+	if redeemCouponRequest.RequestID != "" {
+		//A retry of a committed redemption gets the original response
+		redemptionRecord, err := getRedemptionRecord(stub, redeemCouponRequest)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if redemptionRecord != nil {
+			responseAsBytes, _ := json.Marshal(redemptionRecord.Response)
+			return shim.Success(responseAsBytes)
+		}
+	}
+	eligibility, err := c.checkCouponEligibility(stub, eligibilityRequest{
+		CouponKey: redeemCouponRequest.CouponKey,
+		CustomerKey: redeemCouponRequest.CustomerKey,
+		PartnerKey: redeemCouponRequest.PartnerKey,
+		AssetOriginalPrice: &redeemCouponRequest.AssetOriginalPrice,
+	})
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Unable to fetch coupon %s error : %s", redeemCouponRequest.CouponKey,  err.Error()))
+		return errorResponse(err)
 	}
-	coupon := Coupon{}
-	json.Unmarshal(resultAsBytes, &coupon)
-	//Get Partner Information based on PartnerKey
-	resultAsBytes, err = stub.GetState(redeemCouponRequest.PartnerKey);
+	if !eligibility.Response.IsValid {
+		return errorResponse(rejectionError(strings.ToLower(redeemCouponRequest.CouponKey), eligibility.Response))
+	}
+	coupon := eligibility.Coupon
+	redeemCouponRequest.CouponKey = coupon.Key
+	redeemCouponRequest.PartnerKey = eligibility.Partner.Key
+	now, err := c.clock(stub).Now()
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Unable to fetch partner %s error : %s", redeemCouponRequest.PartnerKey, err.Error()))
+		return errorResponse(err)
 	}
-	partner := Partner{}
-	json.Unmarshal(resultAsBytes, &partner)
-	salesTransaction := prepSalesTransaction(redeemCouponRequest, coupon)
-	salesTransactionAsBytes, err := json.Marshal(salesTransaction)
+	contract, err := getPartnerContractInForce(stub, eligibility.Partner.Key, now)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
-	salesTransactionArgs  := []string{ string(salesTransactionAsBytes) }
-	response := c.CreateSalesTransaction(stub, salesTransactionArgs)
-	if response.Status != 200 {
-		return shim.Error(response.Message)
+	salesTransaction := prepSalesTransaction(redeemCouponRequest, coupon, eligibility.DiscountAmount, eligibility.CurrencyConfig, contract)
+	salesTransaction.CreatedDateTime = now.Format(dateTimeFormat)
+	salesTransaction.UseNumber = coupon.UsesCount + 1
+	err = putNewSalesTransaction(stub, &salesTransaction)
+	if err != nil {
+		return errorResponse(err)
 	}
-	//update coupon status to redeemed
-	coupon.Status = couponStatusRedeemed
-	couponAsBytes, err := json.Marshal(coupon)
-	writeErr := stub.PutState(redeemCouponRequest.CouponKey, couponAsBytes)
+	//record the use, the coupon is redeemed once no uses or balance remain
+	redeemedCoupon := applyCouponUse(coupon, salesTransaction)
+	couponAsBytes, err := json.Marshal(redeemedCoupon)
+	writeErr := stub.PutState(coupon.Key, couponAsBytes)
 	if writeErr != nil {
-		return shim.Error(fmt.Sprintf("Redeem Coupon %s save failed error : %s", redeemCouponRequest.CouponKey, writeErr.Error()))
+		return errorResponse(fmt.Errorf("Redeem Coupon %s save failed error : %s", coupon.Key, writeErr.Error()))
+	}
+	err = updateCustomerCouponIndex(stub, coupon, redeemedCoupon)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = recordCampaignRedemption(stub, coupon, 1, salesTransaction.DiscountAmount)
+	if err != nil {
+		return errorResponse(err)
+	}
+	addEvent(stub, RecordEvent{
+		Type: eventCouponRedeemed,
+		RecordKey: coupon.Key,
+		OldStatus: getDerivedCouponStatus(coupon),
+		NewStatus: getDerivedCouponStatus(redeemedCoupon),
+		Amounts: salesTransactionAmounts(salesTransaction),
+	})
+	redeemCouponResponse := RedeemCouponResponse{
+		Message: "Coupon Redeemed Sucessfully!!!",
+		CouponStatus: getDerivedCouponStatus(redeemedCoupon),
+		SalesTransaction: salesTransaction,
 	}
-	return shim.Success([]byte ("Coupon Redeemed Sucessfully!!!"))
+	if redeemCouponRequest.RequestID != "" {
+		err = putRedemptionRecord(stub, redeemCouponRequest, redeemCouponResponse)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	responseAsBytes, _ := json.Marshal(redeemCouponResponse)
+	return shim.Success(responseAsBytes)
 }
 
-//Function to query coupons based on customer
+func (query CustomerCouponQuery) isPaginated() bool {
+	return query.PageSize != 0 || query.Bookmark != ""
+}
+
+//Function to query coupons based on customer, optionally filtered by status
 func (c *CouponChaincode) QueryCouponsByCustomer(stub shim.ChaincodeStubInterface, args []string) sc.Response {
-	var  couponResponse []CouponResponse
-	var queryKey QueryKey
+	var queryKey CustomerCouponQuery
+	err := parseArgs("querycouponsbycustomer", args, &queryKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	_, customerCoupons, metadata, err := getCustomerCoupons(stub, queryKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	customerCouponsAsBytes, _ := json.Marshal(customerCoupons)
+	if queryKey.isPaginated() {
+		return shim.Success(newPagedQueryResponse(customerCouponsAsBytes, metadata))
+	}
+	return shim.Success(customerCouponsAsBytes)
+}
+
+//Function to query a customer together with its coupons, optionally filtered by status
+func (c *CouponChaincode) QueryCustomerCoupons(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var queryKey CustomerCouponQuery
+	err := parseArgs("querycustomercoupons", args, &queryKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	customer, customerCoupons, metadata, err := getCustomerCoupons(stub, queryKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	customer, err = withCustomerPII(stub, customer)
+	if err != nil {
+		return errorResponse(err)
+	}
+	customerCoupon := CustomerCoupon{ Customer: customer, Coupons: customerCoupons }
+	customerCouponAsBytes, _ := json.Marshal(customerCoupon)
+	if queryKey.isPaginated() {
+		return shim.Success(newPagedQueryResponse(customerCouponAsBytes, metadata))
+	}
+	return shim.Success(customerCouponAsBytes)
+}
+
+//Function to get a customer and its coupons
+func getCustomerCoupons(stub shim.ChaincodeStubInterface, queryKey CustomerCouponQuery) (Customer, []Coupon, *sc.QueryResponseMetadata, error) {
 	customerCoupons := make([]Coupon, 0)
-	json.Unmarshal([]byte (args[0]), &queryKey)
 	customerKey := strings.ToLower(queryKey.Key)
-	couponQuery := "{\"recordType\":\"coupon\"}";
-	couponQueryResponse:= c.QueryByRange(stub, []string { couponQuery })
-	if couponQueryResponse.Status != 200 {
-		return shim.Error(couponQueryResponse.Message)
-	}
-	couponQueryResponseAsString := string(couponQueryResponse.Payload)
-	json.Unmarshal([]byte(couponQueryResponseAsString), &couponResponse)
-
-	for i := 0; i < len(couponResponse); i++ {
-		if couponResponse[i].Coupon.CustomerKey == customerKey {
-			couponResponse[i].Coupon.Key = couponResponse[i].Key
-			customerCoupons = append(customerCoupons, couponResponse[i].Coupon)
+	customer, found, err := getCustomer(stub, customerKey)
+	if err != nil {
+		return Customer{}, nil, nil, err
+	}
+	if !found {
+		return Customer{}, nil, nil, notFoundError(customerKey, "Customer %s does not exist", customerKey)
+	}
+	if queryKey.isPaginated() {
+		err := validatePageSize(queryKey.PageSize)
+		if err != nil {
+			return Customer{}, nil, nil, err
 		}
 	}
-	customerCouponsAsBytes, _ := json.Marshal(customerCoupons)
-	return shim.Success(customerCouponsAsBytes)
+	couponKeys, metadata, err := getCustomerCouponKeys(stub, customerKey, strings.ToUpper(queryKey.Status), queryKey.PageSize, queryKey.Bookmark)
+	if err != nil {
+		return Customer{}, nil, nil, fmt.Errorf("Unable to query coupons for customer %s error : %s", customerKey, err.Error())
+	}
+	for _, couponKey := range couponKeys {
+		coupon, found, err := getCoupon(stub, couponKey)
+		if err != nil {
+			return Customer{}, nil, nil, err
+		}
+		if !found {
+			continue
+		}
+		customerCoupons = append(customerCoupons, coupon)
+	}
+	return customer, customerCoupons, metadata, nil
 }
 
 //Function to get History for a key 
 func (c *CouponChaincode) QueryHistoryByKey(stub shim.ChaincodeStubInterface, args []string) sc.Response {
 	var queryKey QueryKey
-	json.Unmarshal([]byte (args[0]), &queryKey)
+	err := parseArgs("queryhistorybykey", args, &queryKey)
+	if err != nil {
+		return errorResponse(err)
+	}
 	key := strings.ToLower(queryKey.Key)
 	resultsIterator, err := stub.GetHistoryForKey(key)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Error %s while searching for key %s ", err.Error(), queryKey.Key))
+		return errorResponse(fmt.Errorf("Error %s while searching for key %s ", err.Error(), queryKey.Key))
 	}
 	historyForKey , err := generateHistoricalRecordsForKey(resultsIterator)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 	return shim.Success(historyForKey)
 }
@@ -400,79 +820,51 @@ func main () {
     }
 }
 
-// Function to create the sales transaction
-func prepSalesTransaction(redeemCouponRequest RedeemCouponRequest, coupon Coupon) SalesTransaction {
-	salesAmount := redeemCouponRequest.AssetOriginalPrice - coupon.DiscountAmount
-	revenueShareAmount := redeemCouponRequest.AssetOriginalPrice  * (coupon.RevenueSharePercent / 100)
-	settlementAmount := salesAmount - revenueShareAmount
+// Function to create the sales transaction. Amounts are computed with exact decimal
+// arithmetic and rounded once to the scale of the coupon currency. The discount comes
+// from calculateDiscount and never exceeds the price, so SalesAmount is never negative.
+func prepSalesTransaction(redeemCouponRequest RedeemCouponRequest, coupon Coupon, discountAmount decimal.Decimal, currencyConfig CurrencyConfig, contract *PartnerContract) SalesTransaction {
+	assetOriginalPrice := currencyConfig.round(redeemCouponRequest.AssetOriginalPrice)
+	salesAmount := assetOriginalPrice.Sub(discountAmount)
+	//The contract of the partner takes precedence over the percent on the coupon
+	shareBasisAmount, revenueSharePercent := assetOriginalPrice, coupon.RevenueSharePercent
+	if contract != nil {
+		shareBasisAmount, revenueSharePercent = contract.revenueShare(assetOriginalPrice, discountAmount)
+	}
+	revenueShareAmount := currencyConfig.round(percentOf(shareBasisAmount, revenueSharePercent))
+	settlementAmount := salesAmount.Sub(revenueShareAmount)
 	salesTransaction := SalesTransaction { 
 		PartnerKey: redeemCouponRequest.PartnerKey,
 		CouponKey: redeemCouponRequest.CouponKey, 
-		AssetOriginalPrice : redeemCouponRequest.AssetOriginalPrice, 
+		AssetOriginalPrice : assetOriginalPrice, 
+		DiscountAmount : discountAmount,
 		SalesAmount : salesAmount, 
 		RevenueShareAmount: revenueShareAmount, 
 		SettlementAmount: settlementAmount,
+		RevenueSharePercent: revenueSharePercent,
+		Currency: currencyConfig.Code,
 	}
-	return salesTransaction 
-}
-
-// Function to validate the coupon by date
-func hasCouponExpired(expiryDate time.Time) bool {
-	currentTime := time.Now()
-	today := time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(),0,0,0,0, time.UTC)
-	if today.After(expiryDate) || today.Equal(expiryDate) {
-		return false
-	}
-	return true
-}
-
-//Function to get range end key
-func getRecordRangeEndKey(recordType string) (bool , string) {
-	 var isRecordTypeFound bool
-	 var recordRangeEndKey string 
-	 switch (recordType) {
-	 case "coupon" :
-		recordRangeEndKey = couponRangeEndKey
-		isRecordTypeFound = true
-	 case "salestransaction" :
-		recordRangeEndKey = salesTransactionRangeEndKey
-		isRecordTypeFound = true
-	 case "partner" :
-		recordRangeEndKey = partnerRangeEndKey
-		isRecordTypeFound = true
-	default :
-		recordRangeEndKey = fmt.Sprintf("Unknown Record Type : %s", recordType)
-		isRecordTypeFound = false
-	 }
-	 return isRecordTypeFound, recordRangeEndKey
-}
-
-//Function to get key based on record type
-func getKeyByRecordType(recordType string, keyNumber int) string { 
-	return  recordType + ":" + strconv.Itoa(keyNumber)
-}
-
-//Function to get key based on record type
-func generateKey(endRangeKey string) ( string , int ) { 
-    result := strings.Split(endRangeKey, ":")
-    keyNumber, _ := strconv.Atoi(result[1])
-    if  keyNumber == 0 {
-        keyNumber = 101
-    } else {
-        keyNumber += 1
+	if contract != nil {
+		salesTransaction.ContractKey = contract.Key
+		salesTransaction.ContractVersion = contract.Version
+		salesTransaction.ShareBasis = contract.ShareBasis
 	}
-	key := getKeyByRecordType(result[0] , keyNumber)
-	return key , keyNumber
+	return salesTransaction 
 }
 
 //Function to get result based on range
 func getStatebyRangeResult(stub shim.ChaincodeStubInterface, startRangeKey string, endRangeKey string) ([] byte, error) {
 	//Get state by range
-	resultsIterator, err := stub.GetStateByRange(startRangeKey, string(endRangeKey))
-    defer resultsIterator.Close()
-    if err != nil {
-        return nil, err
+	resultsIterator, err := stub.GetStateByRange(startRangeKey, endRangeKey)
+	if err != nil {
+		return nil, err
 	}
+	defer resultsIterator.Close()
+	return generateQueryRecords(resultsIterator)
+}
+
+//Function to generate the JSON array of key and record pairs of a query
+func generateQueryRecords(resultsIterator shim.StateQueryIteratorInterface) ([]byte, error) {
     // buffer is a JSON array containing QueryRecords
     var buffer bytes.Buffer
     buffer.WriteString("[")
@@ -548,45 +940,76 @@ func generateHistoricalRecordsForKey(resultsIterator shim.HistoryQueryIteratorIn
 	buffer.WriteString("]")
 	return buffer.Bytes(),nil
 }
-//Function to initiate ledger with sample Customers
-func (t *CouponChaincode) initCustomers(stub shim.ChaincodeStubInterface) {
+//Function to initiate ledger with sample Customers. Init runs again on every
+//upgrade, so customers already on the ledger are left as they are. The email index
+//secret is passed in because private data written by this transaction can not be
+//read back before it commits.
+func (t *CouponChaincode) initCustomers(stub shim.ChaincodeStubInterface, emailIndexSecret string) error {
     //initiating the ledger with customers
-    customers := []Customer {
-        Customer{ Key : "customer:101", Name:"Louis", Email:"louis@gmail.com" }, 
-        Customer{ Key : "customer:102", Name:"Elizabeth",  Email:"eizabeth@gmail.com" },
-		Customer{ Key : "customer:103", Name:"Henry",  Email:"henry@outlook.com" },
+    customers := []CustomerPII {
+        CustomerPII{ Key : "customer:101", Name:"Louis", Email:"louis@gmail.com", Salt:"sample-salt-customer-101" }, 
+        CustomerPII{ Key : "customer:102", Name:"Elizabeth",  Email:"eizabeth@gmail.com", Salt:"sample-salt-customer-102" },
+		CustomerPII{ Key : "customer:103", Name:"Henry",  Email:"henry@outlook.com", Salt:"sample-salt-customer-103" },
 	}
     for c := 0; c < len(customers); c++ {
-        customerAsBytes, _ := json.Marshal(customers[c])
-        stub.PutState(customers[c].Key, customerAsBytes)
+		_, found, err := getCustomer(stub, customers[c].Key)
+		if err != nil {
+			return err
+		}
+		if found {
+			continue
+		}
+        customer := Customer{ Key: customers[c].Key, Status: customerStatusActive, Version: 1 }
+		err = putCustomerWithPII(stub, customer, customers[c])
+		if err != nil {
+			return err
+		}
+		if emailIndexSecret == "" {
+			//Indexed by setEmailIndexSecret once a secret is set
+			continue
+		}
+		indexKey, err := getEmailIndexKey(stub, emailIndexSecret, customers[c].Email)
+		if err != nil {
+			return err
+		}
+		err = putEmailIndexEntry(stub, indexKey, customers[c].Key)
+		if err != nil {
+			return err
+		}
     }
+	return nil
 }
 
-//Function to initiate ledger with sample Partners
-func (t *CouponChaincode) initPartners(stub shim.ChaincodeStubInterface) {
+//Function to initiate ledger with sample Partners, a partner already on the ledger
+//keeps its status across upgrades
+func (t *CouponChaincode) initPartners(stub shim.ChaincodeStubInterface) error {
     //initiating the ledger with partners
-	partner := Partner{ Key: "partner:101",  Name: "Govberg Jewelers Suburban Square", AddressKey : "address:101"}
+	partner := Partner{ Key: "partner:101",  Name: "Govberg Jewelers Suburban Square", AddressKey : "address:101", Status: partnerStatusActive}
+	_, found, err := getPartner(stub, partner.Key)
+	if err != nil || found {
+		return err
+	}
 	partnerAsBytes, _ := json.Marshal(partner)
-	stub.PutState(partner.Key,  partnerAsBytes)
+	err = stub.PutState(partner.Key,  partnerAsBytes)
+	if err != nil {
+		return fmt.Errorf("Partner %s PutState failed: %s", partner.Key, err.Error())
+	}
+	return putAddressPartnerIndex(stub, partner)
 }
 
-//Function to initiate ledger with sample Addresses
-func (t *CouponChaincode) initAddresses(stub shim.ChaincodeStubInterface) {
+//Function to initiate ledger with sample Addresses, an address already on the
+//ledger is left as it is on upgrade
+func (t *CouponChaincode) initAddresses(stub shim.ChaincodeStubInterface) error {
     //initiating the ledger with addresses
     address := Address{ Key : "address:101", Street:"65, St James Place", ZipCode:"19003", State:"Pennsylvania", Country: "USA"}
+	_, found, err := getAddress(stub, address.Key)
+	if err != nil || found {
+		return err
+	}
  	addressAsBytes, _ := json.Marshal(address)
-   	stub.PutState(address.Key, addressAsBytes)
-}
-
-//Function to initiate ledger with Range Keys
-func (t *CouponChaincode) initRangeKeys(stub shim.ChaincodeStubInterface) {
-	stub.PutState(customerRangeStartKey, []byte("customer:101"))
-	stub.PutState(customerRangeEndKey, []byte("customer:103"))
-	stub.PutState(partnerRangeStartKey, []byte("partner:101"))
-    stub.PutState(partnerRangeEndKey, []byte("partner:101"))
-	stub.PutState(couponRangeStartKey, []byte("coupon:101"))
-    stub.PutState(couponRangeEndKey, []byte("coupon:0"))
-    stub.PutState(salesTransactionRangeStartKey,[]byte ("salestransaction:101"))
-	stub.PutState(salesTransactionRangeEndKey, []byte ("salestransaction:0"))
+	err = stub.PutState(address.Key, addressAsBytes)
+	if err != nil {
+		return fmt.Errorf("Address %s PutState failed: %s", address.Key, err.Error())
+	}
+	return nil
 }
-
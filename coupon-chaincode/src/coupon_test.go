@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	testAdmin  = callerIdentity{MSPID: "Org1MSP", Role: roleAdmin}
+	testIssuer = callerIdentity{MSPID: "Org1MSP", Role: roleIssuer}
+)
+
+type fixedClock time.Time
+
+func (f fixedClock) Now() (time.Time, error) {
+	return time.Time(f), nil
+}
+
+// testLedger drives the handlers on a MockStub as a given caller. Access control
+// reads the caller certificate, which MockStub does not provide, so the caller is
+// set on the transaction directly.
+type testLedger struct {
+	t    *testing.T
+	cc   *CouponChaincode
+	stub *shim.MockStub
+	txNo int
+}
+
+func newTestLedger(t *testing.T, now string) *testLedger {
+	clockTime, err := time.Parse(time.RFC3339, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := &CouponChaincode{clockFactory: func(shim.ChaincodeStubInterface) Clock {
+		return fixedClock(clockTime)
+	}}
+	return &testLedger{t: t, cc: cc, stub: shim.NewMockStub("coupon", cc)}
+}
+
+func (l *testLedger) invoke(caller callerIdentity, fnc string, request interface{}) sc.Response {
+	l.txNo++
+	txID := fmt.Sprintf("tx%d", l.txNo)
+	requestAsBytes, _ := json.Marshal(request)
+	l.stub.MockTransactionStart(txID)
+	defer l.stub.MockTransactionEnd(txID)
+	transaction := newTxStub(l.stub)
+	transaction.caller = caller
+	return l.cc.invokeFunction(transaction, fnc, []string{string(requestAsBytes)})
+}
+
+func (l *testLedger) mustInvoke(caller callerIdentity, fnc string, request interface{}, result interface{}) []byte {
+	l.t.Helper()
+	response := l.invoke(caller, fnc, request)
+	if response.Status != shim.OK {
+		l.t.Fatalf("%s failed : %s", fnc, response.Message)
+	}
+	if result != nil {
+		err := json.Unmarshal(response.Payload, result)
+		if err != nil {
+			l.t.Fatalf("%s returned %s : %s", fnc, string(response.Payload), err.Error())
+		}
+	}
+	return response.Payload
+}
+
+func (l *testLedger) seedPartnerAndCustomer(customerKey string) callerIdentity {
+	var address Address
+	l.mustInvoke(testAdmin, "createaddress", map[string]string{"street": "1 Main Street", "zipCode": "10001", "state": "NY", "country": "US"}, &address)
+	var partner Partner
+	l.mustInvoke(testAdmin, "registerpartner", map[string]string{"name": "Corner Shop", "addressKey": address.Key}, &partner)
+	l.stub.MockTransactionStart("seed")
+	defer l.stub.MockTransactionEnd("seed")
+	customerAsBytes, _ := json.Marshal(Customer{Key: customerKey, Status: customerStatusActive, Version: 1})
+	err := l.stub.PutState(customerKey, customerAsBytes)
+	if err != nil {
+		l.t.Fatal(err)
+	}
+	return callerIdentity{MSPID: "Org1MSP", Role: rolePartner, PartnerKey: partner.Key}
+}
+
+func (l *testLedger) createCoupon(request map[string]string) string {
+	created := l.mustInvoke(testIssuer, "createcoupon", request, nil)
+	return strings.TrimSuffix(string(created), " created successfully")
+}
+
+func (l *testLedger) redeem(partner callerIdentity, couponKey string, customerKey string, price string) sc.Response {
+	return l.invoke(partner, "redeemcoupon", map[string]string{
+		"couponKey":          couponKey,
+		"customerKey":        customerKey,
+		"partnerKey":         partner.PartnerKey,
+		"assetOriginalPrice": price,
+	})
+}
+
+func (l *testLedger) mustRedeem(partner callerIdentity, couponKey string, customerKey string, price string) RedeemCouponResponse {
+	l.t.Helper()
+	var redeemed RedeemCouponResponse
+	response := l.redeem(partner, couponKey, customerKey, price)
+	if response.Status != shim.OK {
+		l.t.Fatalf("redeemcoupon failed : %s", response.Message)
+	}
+	err := json.Unmarshal(response.Payload, &redeemed)
+	if err != nil {
+		l.t.Fatal(err)
+	}
+	return redeemed
+}
+
+func (l *testLedger) getCoupon(couponKey string) Coupon {
+	coupon, found, err := getCoupon(l.stub, couponKey)
+	if err != nil || !found {
+		l.t.Fatalf("coupon %s not found : %v", couponKey, err)
+	}
+	return coupon
+}
+
+func assertAmount(t *testing.T, name string, got decimal.Decimal, want string) {
+	t.Helper()
+	if !got.Equal(decimal.RequireFromString(want)) {
+		t.Errorf("%s = %s, want %s", name, got, want)
+	}
+}
+
+func TestRedeemCouponOnlyOnce(t *testing.T) {
+	ledger := newTestLedger(t, "2019-06-15T10:00:00Z")
+	customerKey := "customer:alice"
+	partner := ledger.seedPartnerAndCustomer(customerKey)
+	couponKey := ledger.createCoupon(map[string]string{
+		"name":                "Summer Sale",
+		"expiresOn":           "31-12-2019",
+		"discountAmount":      "10",
+		"revenueSharePercent": "10",
+		"customerKey":         customerKey,
+	})
+	if coupon := ledger.getCoupon(couponKey); coupon.Status != couponStatusIssued {
+		t.Fatalf("new coupon status = %s, want %s", coupon.Status, couponStatusIssued)
+	}
+	if response := ledger.redeem(partner, couponKey, "customer:bob", "100"); response.Status == shim.OK {
+		t.Fatalf("coupon was redeemed for another customer")
+	}
+
+	redeemed := ledger.mustRedeem(partner, couponKey, customerKey, "100")
+	sale := redeemed.SalesTransaction
+	if redeemed.CouponStatus != couponStatusRedeemed {
+		t.Errorf("redeemed coupon status = %s, want %s", redeemed.CouponStatus, couponStatusRedeemed)
+	}
+	assertAmount(t, "discount", sale.DiscountAmount, "10")
+	assertAmount(t, "sales amount", sale.SalesAmount, "90")
+	assertAmount(t, "revenue share", sale.RevenueShareAmount, "10")
+	assertAmount(t, "settlement amount", sale.SettlementAmount, "80")
+
+	if response := ledger.redeem(partner, couponKey, customerKey, "100"); response.Status == shim.OK {
+		t.Fatalf("redeemed coupon was redeemed again")
+	}
+}
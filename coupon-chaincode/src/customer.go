@@ -0,0 +1,453 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	customerStatusActive   = "ACTIVE"
+	customerStatusInactive = "INACTIVE"
+	// Private index of the customer key by email, kept in the PII collection so
+	// the uniqueness check never exposes an email on the public ledger
+	customerEmailIndex    = "email~customer"
+	maxCustomerNameLength = 100
+)
+
+var emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// CustomerUpdateRequest identifies the customer and the version the caller read.
+// The update is rejected when the customer has been changed since.
+type CustomerUpdateRequest struct {
+	Key     string `json:"key"`
+	Version int    `json:"version"`
+}
+
+type CustomerEmailQuery struct {
+	Email string `json:"email"`
+}
+
+//Function to create a customer, the PII is passed in the transient map
+func (c *CouponChaincode) CreateCustomer(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	err := parseArgs("createcustomer", args, nil)
+	if err != nil {
+		return errorResponse(err)
+	}
+	customerPII, err := getCustomerPIIFromTransient(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = validateCustomerPII(customerPII)
+	if err != nil {
+		return errorResponse(err)
+	}
+	existingCustomerKey, err := getCustomerKeyByEmail(stub, customerPII.Email)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if existingCustomerKey != "" {
+		return errorResponse(conflictError(existingCustomerKey, "A customer with this email already exists : %s", existingCustomerKey).withField("email"))
+	}
+	now, err := c.clock(stub).Now()
+	if err != nil {
+		return errorResponse(err)
+	}
+	customer := Customer{
+		Key:             generateKey(stub, customerKeyPrefix),
+		Status:          customerStatusActive,
+		Version:         1,
+		CreatedDateTime: now.Format(dateTimeFormat),
+	}
+	customerPII.Key = customer.Key
+	err = putCustomerWithPII(stub, customer, customerPII)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = putCustomerEmailIndex(stub, customerPII)
+	if err != nil {
+		return errorResponse(err)
+	}
+	customerAsBytes, _ := json.Marshal(customer)
+	return shim.Success(customerAsBytes)
+}
+
+//Function to replace the PII of a customer, the PII is passed in the transient map
+func (c *CouponChaincode) UpdateCustomer(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var request CustomerUpdateRequest
+	err := parseArgs("updatecustomer", args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+	customer, err := getCustomerForUpdate(stub, request)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if customer.Status == customerStatusInactive {
+		return errorResponse(conflictError(customer.Key, "Customer %s is inactive", customer.Key))
+	}
+	customerPII, err := getCustomerPIIFromTransient(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = validateCustomerPII(customerPII)
+	if err != nil {
+		return errorResponse(err)
+	}
+	customerPII.Key = customer.Key
+	previousPII, hasPreviousPII, err := getCustomerPII(stub, customer.Key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !hasPreviousPII || previousPII.Email != customerPII.Email {
+		existingCustomerKey, err := getCustomerKeyByEmail(stub, customerPII.Email)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if existingCustomerKey != "" && existingCustomerKey != customer.Key {
+			return errorResponse(conflictError(existingCustomerKey, "A customer with this email already exists : %s", existingCustomerKey).withField("email"))
+		}
+		if hasPreviousPII {
+			err = delCustomerEmailIndex(stub, previousPII)
+			if err != nil {
+				return errorResponse(err)
+			}
+		}
+		err = putCustomerEmailIndex(stub, customerPII)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	now, err := c.clock(stub).Now()
+	if err != nil {
+		return errorResponse(err)
+	}
+	customer.Version++
+	customer.UpdatedDateTime = now.Format(dateTimeFormat)
+	err = putCustomerWithPII(stub, customer, customerPII)
+	if err != nil {
+		return errorResponse(err)
+	}
+	customerAsBytes, _ := json.Marshal(customer)
+	return shim.Success(customerAsBytes)
+}
+
+//Function to deactivate a customer, the coupons of an inactive customer can not be redeemed
+func (c *CouponChaincode) DeactivateCustomer(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var request CustomerUpdateRequest
+	err := parseArgs("deactivatecustomer", args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+	customer, err := getCustomerForUpdate(stub, request)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if customer.Status == customerStatusInactive {
+		return errorResponse(conflictError(customer.Key, "Customer %s is already inactive", customer.Key))
+	}
+	now, err := c.clock(stub).Now()
+	if err != nil {
+		return errorResponse(err)
+	}
+	previousStatus := customer.Status
+	customer.Status = customerStatusInactive
+	customer.Version++
+	customer.UpdatedDateTime = now.Format(dateTimeFormat)
+	customerAsBytes, _ := json.Marshal(customer)
+	writeErr := stub.PutState(customer.Key, customerAsBytes)
+	if writeErr != nil {
+		return errorResponse(fmt.Errorf("Customer %s PutState failed: %s", customer.Key, writeErr.Error()))
+	}
+	addEvent(stub, RecordEvent{
+		Type:      eventCustomerStatusChanged,
+		RecordKey: customer.Key,
+		OldStatus: previousStatus,
+		NewStatus: customer.Status,
+	})
+	return shim.Success(customerAsBytes)
+}
+
+//Function to look up a customer by email, only available to members of the PII collection
+func (c *CouponChaincode) GetCustomerByEmail(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var query CustomerEmailQuery
+	err := parseArgs("getcustomerbyemail", args, &query)
+	if err != nil {
+		return errorResponse(err)
+	}
+	email := strings.ToLower(strings.TrimSpace(query.Email))
+	customerKey, err := getCustomerKeyByEmail(stub, email)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if customerKey == "" {
+		return errorResponse(notFoundError("", "No customer found for email %s", email).withField("email"))
+	}
+	customer, found, err := getCustomer(stub, customerKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !found {
+		return errorResponse(notFoundError(customerKey, "Customer %s does not exist", customerKey))
+	}
+	customer, err = withCustomerPII(stub, customer)
+	if err != nil {
+		return errorResponse(err)
+	}
+	customerAsBytes, _ := json.Marshal(customer)
+	return shim.Success(customerAsBytes)
+}
+
+//Function to get the public customer record
+func getCustomer(stub shim.ChaincodeStubInterface, customerKey string) (Customer, bool, error) {
+	var customer Customer
+	customerKey = strings.ToLower(customerKey)
+	resultAsBytes, err := stub.GetState(customerKey)
+	if err != nil {
+		return customer, false, fmt.Errorf("Unable to fetch customer %s error : %s", customerKey, err.Error())
+	}
+	if resultAsBytes == nil {
+		return customer, false, nil
+	}
+	err = json.Unmarshal(resultAsBytes, &customer)
+	if err != nil {
+		return customer, false, fmt.Errorf("Invalid customer record %s error : %s", customerKey, err.Error())
+	}
+	customer.Key = customerKey
+	if customer.Status == "" {
+		customer.Status = customerStatusActive
+	}
+	return customer, true, nil
+}
+
+//Function to get a customer and check the version the caller is updating
+func getCustomerForUpdate(stub shim.ChaincodeStubInterface, request CustomerUpdateRequest) (Customer, error) {
+	customer, found, err := getCustomer(stub, request.Key)
+	if err != nil {
+		return customer, err
+	}
+	if !found {
+		return customer, notFoundError(request.Key, "Customer %s does not exist", request.Key)
+	}
+	if customer.Version != request.Version {
+		return customer, conflictError(customer.Key, "Customer %s has been modified, expected version %d but found %d", customer.Key, request.Version, customer.Version).withField("version")
+	}
+	return customer, nil
+}
+
+//Function to write the public customer record with the hash of its PII and the PII itself
+func putCustomerWithPII(stub shim.ChaincodeStubInterface, customer Customer, customerPII CustomerPII) error {
+	customer.Name = ""
+	customer.Email = ""
+	customer.PIIHash = hashCustomerPII(customerPII)
+	err := putCustomerPII(stub, customerPII)
+	if err != nil {
+		return err
+	}
+	customerAsBytes, _ := json.Marshal(customer)
+	err = stub.PutState(customer.Key, customerAsBytes)
+	if err != nil {
+		return fmt.Errorf("Customer %s PutState failed: %s", customer.Key, err.Error())
+	}
+	return nil
+}
+
+//Function to validate the customer fields
+func validateCustomerPII(customerPII CustomerPII) error {
+	if customerPII.Name == "" {
+		return invalidArgumentError("name", "Customer name is required")
+	}
+	if len(customerPII.Name) > maxCustomerNameLength {
+		return invalidArgumentError("name", "Customer name must not exceed %d characters", maxCustomerNameLength)
+	}
+	if !emailPattern.MatchString(customerPII.Email) {
+		return invalidArgumentError("email", "Invalid customer email : %s", customerPII.Email)
+	}
+	return nil
+}
+
+//Function to get the email index key under the secret, an empty secret gives the
+//key of entries written before the index was keyed by a secret
+func getEmailIndexKey(stub shim.ChaincodeStubInterface, secret string, email string) (string, error) {
+	if secret == "" {
+		return stub.CreateCompositeKey(customerEmailIndex, []string{email})
+	}
+	return stub.CreateCompositeKey(customerEmailIndex, []string{hashEmail(secret, email)})
+}
+
+//Function to get the email index key under the current secret
+func getCustomerEmailIndexKey(stub shim.ChaincodeStubInterface, email string) (string, error) {
+	secret, err := getEmailIndexSecret(stub)
+	if err != nil {
+		return "", err
+	}
+	if secret == "" {
+		return "", conflictError(emailIndexSecretKey, "The email index secret has not been set, an admin must call setEmailIndexSecret")
+	}
+	return getEmailIndexKey(stub, secret, email)
+}
+
+//Function to get the key of the customer registered with the email
+func getCustomerKeyByEmail(stub shim.ChaincodeStubInterface, email string) (string, error) {
+	indexKey, err := getCustomerEmailIndexKey(stub, email)
+	if err != nil {
+		return "", err
+	}
+	customerKeyAsBytes, err := stub.GetPrivateData(customerPIICollection, indexKey)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read customer email index error : %s", err.Error())
+	}
+	return string(customerKeyAsBytes), nil
+}
+
+func putCustomerEmailIndex(stub shim.ChaincodeStubInterface, customerPII CustomerPII) error {
+	indexKey, err := getCustomerEmailIndexKey(stub, customerPII.Email)
+	if err != nil {
+		return err
+	}
+	return putEmailIndexEntry(stub, indexKey, customerPII.Key)
+}
+
+func putEmailIndexEntry(stub shim.ChaincodeStubInterface, indexKey string, customerKey string) error {
+	err := stub.PutPrivateData(customerPIICollection, indexKey, []byte(customerKey))
+	if err != nil {
+		return fmt.Errorf("Unable to write customer email index error : %s", err.Error())
+	}
+	return nil
+}
+
+//Function to delete the email index entry of a customer, there is none to delete
+//while no email index secret is set
+func delCustomerEmailIndex(stub shim.ChaincodeStubInterface, customerPII CustomerPII) error {
+	secret, err := getEmailIndexSecret(stub)
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return nil
+	}
+	indexKey, err := getEmailIndexKey(stub, secret, customerPII.Email)
+	if err != nil {
+		return err
+	}
+	err = stub.DelPrivateData(customerPIICollection, indexKey)
+	if err != nil {
+		return fmt.Errorf("Unable to delete customer email index error : %s", err.Error())
+	}
+	return nil
+}
+
+//Function to set the secret keying the email index, passed in the transient map
+//under "emailIndexSecret". Every entry is moved to its key under the new secret,
+//including entries written before the index was keyed by a secret.
+func (c *CouponChaincode) SetEmailIndexSecret(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	err := parseArgs("setemailindexsecret", args, nil)
+	if err != nil {
+		return errorResponse(err)
+	}
+	secret, ok, err := getEmailIndexSecretFromTransient(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !ok {
+		return errorResponse(invalidArgumentError(emailIndexSecretTransientKey, "The email index secret must be passed in the transient map under %q", emailIndexSecretTransientKey))
+	}
+	indexed, err := setEmailIndexSecret(stub, secret)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success([]byte(fmt.Sprintf("Email index rebuilt for %d customers", indexed)))
+}
+
+//Function to set the email index secret at instantiation when none has been set
+//yet, returning the secret in force for the rest of the transaction
+func (t *CouponChaincode) initEmailIndexSecret(stub shim.ChaincodeStubInterface) (string, error) {
+	previousSecret, err := getEmailIndexSecret(stub)
+	if err != nil {
+		return "", err
+	}
+	secret, ok, err := getEmailIndexSecretFromTransient(stub)
+	if err != nil {
+		return "", err
+	}
+	if previousSecret != "" || !ok {
+		return previousSecret, nil
+	}
+	_, err = setEmailIndexSecret(stub, secret)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+//Function to store the email index secret and rebuild the index under it
+func setEmailIndexSecret(stub shim.ChaincodeStubInterface, secret string) (int, error) {
+	previousSecret, err := getEmailIndexSecret(stub)
+	if err != nil {
+		return 0, err
+	}
+	startRangeKey, endRangeKey := getRecordTypeRange(customerKeyPrefix)
+	resultsIterator, err := stub.GetPrivateDataByRange(customerPIICollection, startRangeKey, endRangeKey)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to read customer PII error : %s", err.Error())
+	}
+	defer resultsIterator.Close()
+	indexed := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		var customerPII CustomerPII
+		err = json.Unmarshal(queryResponse.Value, &customerPII)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid PII of customer %s error : %s", queryResponse.Key, err.Error())
+		}
+		previousIndexKey, err := getEmailIndexKey(stub, previousSecret, customerPII.Email)
+		if err != nil {
+			return 0, err
+		}
+		indexKey, err := getEmailIndexKey(stub, secret, customerPII.Email)
+		if err != nil {
+			return 0, err
+		}
+		err = stub.DelPrivateData(customerPIICollection, previousIndexKey)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to delete customer email index error : %s", err.Error())
+		}
+		err = putEmailIndexEntry(stub, indexKey, queryResponse.Key)
+		if err != nil {
+			return 0, err
+		}
+		indexed++
+	}
+	err = stub.PutPrivateData(customerPIICollection, emailIndexSecretKey, []byte(secret))
+	if err != nil {
+		return 0, fmt.Errorf("Unable to write email index secret error : %s", err.Error())
+	}
+	return indexed, nil
+}
+
+//Function to remove the private data of a customer being deleted
+func deleteCustomerPII(stub shim.ChaincodeStubInterface, customerKey string) error {
+	customerPII, ok, err := getCustomerPII(stub, customerKey)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	err = delCustomerEmailIndex(stub, customerPII)
+	if err != nil {
+		return err
+	}
+	err = stub.DelPrivateData(customerPIICollection, customerKey)
+	if err != nil {
+		return fmt.Errorf("Customer %s DelPrivateData failed : %s", customerKey, err.Error())
+	}
+	return nil
+}
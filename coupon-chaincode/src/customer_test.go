@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInitCustomersIndexesEmailsAndKeepsExistingCustomers(t *testing.T) {
+	ledger := newTestLedger(t, "2019-06-15T10:00:00Z")
+	secret := strings.Repeat("s", minimumEmailIndexSecretLength)
+	ledger.stub.MockTransactionStart("init")
+	err := ledger.cc.initCustomers(ledger.stub, secret)
+	if err != nil {
+		t.Fatalf("initCustomers failed : %s", err.Error())
+	}
+	err = ledger.stub.PutPrivateData(customerPIICollection, emailIndexSecretKey, []byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger.stub.MockTransactionEnd("init")
+
+	customerKey, err := getCustomerKeyByEmail(ledger.stub, "Louis@gmail.com")
+	if err != nil || customerKey != "customer:101" {
+		t.Fatalf("customer by email = %q, %v, want customer:101", customerKey, err)
+	}
+
+	var customer Customer
+	ledger.mustInvoke(testAdmin, "deactivatecustomer", map[string]interface{}{"key": "customer:101", "version": 1}, &customer)
+	ledger.stub.MockTransactionStart("upgrade")
+	err = ledger.cc.initCustomers(ledger.stub, secret)
+	ledger.stub.MockTransactionEnd("upgrade")
+	if err != nil {
+		t.Fatalf("initCustomers on upgrade failed : %s", err.Error())
+	}
+	customer, found, err := getCustomer(ledger.stub, "customer:101")
+	if err != nil || !found {
+		t.Fatalf("customer:101 not found : %v", err)
+	}
+	if customer.Status != customerStatusInactive || customer.Version != 2 {
+		t.Errorf("customer after upgrade status = %s version = %d, want %s version 2", customer.Status, customer.Version, customerStatusInactive)
+	}
+}
+
+func TestDelCustomerEmailIndexWithoutSecret(t *testing.T) {
+	ledger := newTestLedger(t, "2019-06-15T10:00:00Z")
+	ledger.stub.MockTransactionStart("delete")
+	defer ledger.stub.MockTransactionEnd("delete")
+	err := delCustomerEmailIndex(ledger.stub, CustomerPII{Key: "customer:101", Email: "louis@gmail.com"})
+	if err != nil {
+		t.Errorf("delCustomerEmailIndex without a secret failed : %s", err.Error())
+	}
+}
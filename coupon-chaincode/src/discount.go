@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// Discount types of a coupon. FIXED subtracts DiscountAmount, PERCENTAGE takes
+// DiscountPercent of the original price and PERCENTAGE_CAPPED additionally limits
+// that to MaxDiscountAmount. Any type may require MinimumPurchaseAmount.
+const (
+	discountTypeFixed            = "FIXED"
+	discountTypePercentage       = "PERCENTAGE"
+	discountTypePercentageCapped = "PERCENTAGE_CAPPED"
+
+	// Policies applied when the discount exceeds the original price
+	excessDiscountClamp  = "CLAMP"
+	excessDiscountReject = "REJECT"
+
+	reasonMinimumSpendNotMet   = "MINIMUM_SPEND_NOT_MET"
+	reasonDiscountExceedsPrice = "DISCOUNT_EXCEEDS_PRICE"
+)
+
+// discountRejection is returned when the coupon can not be applied to the price.
+type discountRejection struct {
+	Reason  string
+	Message string
+}
+
+func (d *discountRejection) Error() string {
+	return d.Message
+}
+
+//Function to check and normalize the discount fields of a new coupon
+func validateDiscount(coupon *Coupon) error {
+	coupon.DiscountType = strings.ToUpper(coupon.DiscountType)
+	if coupon.DiscountType == "" {
+		coupon.DiscountType = discountTypeFixed
+	}
+	coupon.ExcessDiscountPolicy = strings.ToUpper(coupon.ExcessDiscountPolicy)
+	if coupon.ExcessDiscountPolicy == "" {
+		coupon.ExcessDiscountPolicy = excessDiscountClamp
+	}
+	if coupon.ExcessDiscountPolicy != excessDiscountClamp && coupon.ExcessDiscountPolicy != excessDiscountReject {
+		return invalidArgumentError("excessDiscountPolicy", "Invalid excess discount policy : %s", coupon.ExcessDiscountPolicy)
+	}
+	if coupon.MinimumPurchaseAmount.IsNegative() {
+		return invalidArgumentError("minimumPurchaseAmount", "Minimum purchase amount must not be negative")
+	}
+	switch coupon.DiscountType {
+	case discountTypeFixed, discountTypeStoredValue:
+		if !coupon.DiscountAmount.IsPositive() {
+			return invalidArgumentError("discountAmount", "Discount amount must be positive for %s coupons", coupon.DiscountType)
+		}
+	case discountTypePercentage, discountTypePercentageCapped:
+		if !coupon.DiscountPercent.IsPositive() || coupon.DiscountPercent.GreaterThan(decimal.New(100, 0)) {
+			return invalidArgumentError("discountPercent", "Discount percent must be greater than 0 and at most 100")
+		}
+		if coupon.DiscountType == discountTypePercentageCapped && !coupon.MaxDiscountAmount.IsPositive() {
+			return invalidArgumentError("maxDiscountAmount", "Max discount amount must be positive for %s coupons", coupon.DiscountType)
+		}
+	default:
+		return invalidArgumentError("discountType", "Invalid discount type : %s", coupon.DiscountType)
+	}
+	return nil
+}
+
+//Function to calculate the discount of the coupon for the original price. The
+//result is rounded to the currency scale and never exceeds the price, unless the
+//coupon uses the REJECT policy in which case a discountRejection is returned.
+func calculateDiscount(coupon Coupon, assetOriginalPrice decimal.Decimal, currencyConfig CurrencyConfig) (decimal.Decimal, error) {
+	if assetOriginalPrice.LessThan(coupon.MinimumPurchaseAmount) {
+		return decimal.Zero, &discountRejection{
+			Reason:  reasonMinimumSpendNotMet,
+			Message: fmt.Sprintf("Minimum spend of %s not met by %s", coupon.MinimumPurchaseAmount, assetOriginalPrice),
+		}
+	}
+	var discountAmount decimal.Decimal
+	switch coupon.DiscountType {
+	case discountTypePercentage:
+		discountAmount = percentOf(assetOriginalPrice, coupon.DiscountPercent)
+	case discountTypePercentageCapped:
+		discountAmount = decimal.Min(percentOf(assetOriginalPrice, coupon.DiscountPercent), coupon.MaxDiscountAmount)
+	case discountTypeStoredValue:
+		//The remaining balance is used up to the price, the excess policy does not apply
+		return decimal.Min(currencyConfig.round(coupon.RemainingBalance), assetOriginalPrice), nil
+	default:
+		discountAmount = coupon.DiscountAmount
+	}
+	discountAmount = currencyConfig.round(discountAmount)
+	if discountAmount.GreaterThan(assetOriginalPrice) {
+		if coupon.ExcessDiscountPolicy == excessDiscountReject {
+			return decimal.Zero, &discountRejection{
+				Reason:  reasonDiscountExceedsPrice,
+				Message: fmt.Sprintf("Discount of %s exceeds the price of %s", discountAmount, assetOriginalPrice),
+			}
+		}
+		discountAmount = assetOriginalPrice
+	}
+	return discountAmount, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCalculateDiscount(t *testing.T) {
+	usd := defaultCurrencyConfigs["USD"]
+	jpy := defaultCurrencyConfigs["JPY"]
+	amount := decimal.RequireFromString
+	tests := []struct {
+		name       string
+		coupon     Coupon
+		price      string
+		currency   CurrencyConfig
+		want       string
+		wantReason string
+	}{
+		{
+			name:     "fixed",
+			coupon:   Coupon{DiscountType: discountTypeFixed, DiscountAmount: amount("10")},
+			price:    "100",
+			currency: usd,
+			want:     "10",
+		},
+		{
+			name:     "fixed above price is clamped",
+			coupon:   Coupon{DiscountType: discountTypeFixed, DiscountAmount: amount("150"), ExcessDiscountPolicy: excessDiscountClamp},
+			price:    "100",
+			currency: usd,
+			want:     "100",
+		},
+		{
+			name:       "fixed above price is rejected",
+			coupon:     Coupon{DiscountType: discountTypeFixed, DiscountAmount: amount("150"), ExcessDiscountPolicy: excessDiscountReject},
+			price:      "100",
+			currency:   usd,
+			wantReason: reasonDiscountExceedsPrice,
+		},
+		{
+			name:     "percentage is rounded to the currency scale",
+			coupon:   Coupon{DiscountType: discountTypePercentage, DiscountPercent: amount("15")},
+			price:    "33.33",
+			currency: usd,
+			want:     "5.00",
+		},
+		{
+			name:     "percentage in a zero scale currency",
+			coupon:   Coupon{DiscountType: discountTypePercentage, DiscountPercent: amount("15")},
+			price:    "999",
+			currency: jpy,
+			want:     "150",
+		},
+		{
+			name:     "capped percentage below the cap",
+			coupon:   Coupon{DiscountType: discountTypePercentageCapped, DiscountPercent: amount("50"), MaxDiscountAmount: amount("20")},
+			price:    "30",
+			currency: usd,
+			want:     "15",
+		},
+		{
+			name:     "capped percentage above the cap",
+			coupon:   Coupon{DiscountType: discountTypePercentageCapped, DiscountPercent: amount("50"), MaxDiscountAmount: amount("20")},
+			price:    "100",
+			currency: usd,
+			want:     "20",
+		},
+		{
+			name:     "minimum spend met exactly",
+			coupon:   Coupon{DiscountType: discountTypeFixed, DiscountAmount: amount("5"), MinimumPurchaseAmount: amount("50")},
+			price:    "50",
+			currency: usd,
+			want:     "5",
+		},
+		{
+			name:       "minimum spend not met",
+			coupon:     Coupon{DiscountType: discountTypeFixed, DiscountAmount: amount("5"), MinimumPurchaseAmount: amount("50")},
+			price:      "49.99",
+			currency:   usd,
+			wantReason: reasonMinimumSpendNotMet,
+		},
+		{
+			name:     "stored value is used up to the price",
+			coupon:   Coupon{DiscountType: discountTypeStoredValue, RemainingBalance: amount("30"), ExcessDiscountPolicy: excessDiscountReject},
+			price:    "20",
+			currency: usd,
+			want:     "20",
+		},
+		{
+			name:     "stored value below the price",
+			coupon:   Coupon{DiscountType: discountTypeStoredValue, RemainingBalance: amount("12.50")},
+			price:    "20",
+			currency: usd,
+			want:     "12.50",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := calculateDiscount(tt.coupon, amount(tt.price), tt.currency)
+			if tt.wantReason != "" {
+				rejection, ok := err.(*discountRejection)
+				if !ok {
+					t.Fatalf("calculateDiscount error = %v, want rejection %s", err, tt.wantReason)
+				}
+				if rejection.Reason != tt.wantReason {
+					t.Errorf("calculateDiscount reason = %s, want %s", rejection.Reason, tt.wantReason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("calculateDiscount error = %v", err)
+			}
+			if !got.Equal(amount(tt.want)) {
+				t.Errorf("calculateDiscount = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
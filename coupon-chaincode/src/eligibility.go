@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/shopspring/decimal"
+)
+
+// Machine readable reasons reported when a coupon cannot be used.
+const (
+	reasonCouponNotFound     = "COUPON_NOT_FOUND"
+	reasonCustomerMismatch   = "CUSTOMER_MISMATCH"
+	reasonCustomerInactive   = "CUSTOMER_INACTIVE"
+	reasonAlreadyRedeemed    = "ALREADY_REDEEMED"
+	reasonInvalidStatus      = "INVALID_STATUS"
+	reasonExpired            = "EXPIRED"
+	reasonPartnerNotFound    = "PARTNER_NOT_FOUND"
+	reasonPartnerSuspended   = "PARTNER_SUSPENDED"
+	reasonPartnerNotEligible = "PARTNER_NOT_ELIGIBLE"
+)
+
+type eligibilityRequest struct {
+	CouponKey   string
+	CustomerKey string
+	PartnerKey  string
+	// Optional, when set the discount for the price is calculated and checked
+	AssetOriginalPrice *decimal.Decimal
+}
+
+type eligibilityResult struct {
+	Coupon         Coupon
+	Partner        Partner
+	CurrencyConfig CurrencyConfig
+	DiscountAmount decimal.Decimal
+	Response       ValidateCouponResponse
+}
+
+//Function to check whether a coupon can be used by the customer at the partner.
+//The checks read the coupon and partner inside the calling transaction, so a
+//redemption racing with another one on the same coupon fails MVCC validation.
+func (c *CouponChaincode) checkCouponEligibility(stub shim.ChaincodeStubInterface, request eligibilityRequest) (eligibilityResult, error) {
+	var result eligibilityResult
+	couponKey := strings.ToLower(request.CouponKey)
+	resultAsBytes, err := stub.GetState(couponKey)
+	if err != nil {
+		return result, fmt.Errorf("Unable to fetch coupon %s error : %s", couponKey, err.Error())
+	}
+	if resultAsBytes == nil {
+		return result.reject(reasonCouponNotFound, fmt.Sprintf("Coupon %s does not exist", couponKey)), nil
+	}
+	err = json.Unmarshal(resultAsBytes, &result.Coupon)
+	if err != nil {
+		return result, fmt.Errorf("Invalid coupon record %s error : %s", couponKey, err.Error())
+	}
+	result.Coupon.Key = couponKey
+	result.Coupon.CustomerKey = strings.ToLower(result.Coupon.CustomerKey)
+	if result.Coupon.CustomerKey != strings.ToLower(request.CustomerKey) {
+		return result.reject(reasonCustomerMismatch, fmt.Sprintf("Invalid Coupon : %s for Customer : %s", couponKey, request.CustomerKey)), nil
+	}
+	customer, found, err := getCustomer(stub, result.Coupon.CustomerKey)
+	if err != nil {
+		return result, err
+	}
+	if found && customer.Status == customerStatusInactive {
+		return result.reject(reasonCustomerInactive, fmt.Sprintf("Customer %s is inactive", customer.Key)), nil
+	}
+	switch result.Coupon.Status {
+	case couponStatusIssued:
+	case couponStatusRedeemed:
+		return result.reject(reasonAlreadyRedeemed, fmt.Sprintf("Coupon %s has already been redeemed", couponKey)), nil
+	default:
+		return result.reject(reasonInvalidStatus, fmt.Sprintf("Invalid Coupon status : %s", result.Coupon.Status)), nil
+	}
+	now, err := c.clock(stub).Now()
+	if err != nil {
+		return result, err
+	}
+	hasExpired, err := hasCouponExpired(result.Coupon.ExpiresOn, result.Coupon.TimeZone, now)
+	if err != nil {
+		return result, err
+	}
+	if hasExpired {
+		return result.reject(reasonExpired, fmt.Sprintf("Coupon %s has expired!!! ", couponKey)), nil
+	}
+	if request.PartnerKey != "" {
+		partner, found, err := getPartner(stub, request.PartnerKey)
+		if err != nil {
+			return result, err
+		}
+		if !found {
+			return result.reject(reasonPartnerNotFound, fmt.Sprintf("Partner %s does not exist", request.PartnerKey)), nil
+		}
+		result.Partner = partner
+		if partner.Status == partnerStatusSuspended {
+			return result.reject(reasonPartnerSuspended, fmt.Sprintf("Partner %s is suspended", partner.Key)), nil
+		}
+		if !isPartnerEligible(result.Coupon, partner) {
+			return result.reject(reasonPartnerNotEligible, describePartnerIneligibility(result.Coupon, partner)), nil
+		}
+		regionMessage, err := checkPartnerRegion(stub, result.Coupon, partner)
+		if err != nil {
+			return result, err
+		}
+		if regionMessage != "" {
+			return result.reject(reasonOutsideRegion, regionMessage), nil
+		}
+	}
+	if request.AssetOriginalPrice != nil {
+		if request.AssetOriginalPrice.IsNegative() {
+			return result, invalidArgumentError("assetOriginalPrice", "Asset original price must not be negative")
+		}
+		result.CurrencyConfig, err = getCurrencyConfig(stub, result.Coupon.Currency)
+		if err != nil {
+			return result, err
+		}
+		result.DiscountAmount, err = calculateDiscount(result.Coupon, result.CurrencyConfig.round(*request.AssetOriginalPrice), result.CurrencyConfig)
+		if rejection, ok := err.(*discountRejection); ok {
+			return result.reject(rejection.Reason, rejection.Message), nil
+		}
+		if err != nil {
+			return result, err
+		}
+		rejection, err := checkCampaignBudget(stub, result.Coupon, result.DiscountAmount)
+		if err != nil {
+			return result, err
+		}
+		if rejection != nil {
+			return result.reject(rejection.Reason, rejection.Message), nil
+		}
+		discountAmount := result.DiscountAmount
+		result.Response.DiscountAmount = &discountAmount
+	}
+	result.Response.Status = getDerivedCouponStatus(result.Coupon)
+	if hasUseLimit(result.Coupon) {
+		result.Response.RemainingUses = getMaxUses(result.Coupon) - result.Coupon.UsesCount
+	}
+	if result.Coupon.DiscountType == discountTypeStoredValue {
+		remainingBalance := result.Coupon.RemainingBalance
+		result.Response.RemainingBalance = &remainingBalance
+	}
+	result.Response.IsValid = true
+	result.Response.Message = fmt.Sprintf("Valid Coupon %s!!!", couponKey)
+	return result, nil
+}
+
+func (r eligibilityResult) reject(reason string, message string) eligibilityResult {
+	r.Response.IsValid = false
+	r.Response.Reason = reason
+	r.Response.Message = message
+	return r
+}
+
+//Function to normalize the partner allow-lists of a new coupon
+func normalizePartnerEligibility(coupon *Coupon) {
+	coupon.EligiblePartnerKeys = normalizeList(coupon.EligiblePartnerKeys, strings.ToLower)
+	coupon.EligiblePartnerGroups = normalizeList(coupon.EligiblePartnerGroups, strings.ToUpper)
+	coupon.EligiblePartnerCategories = normalizeList(coupon.EligiblePartnerCategories, strings.ToUpper)
+}
+
+//Function to check the partner against the allow-lists of the coupon. A coupon
+//without allow-lists is accepted by every partner, otherwise the partner must
+//match any one of the lists.
+func isPartnerEligible(coupon Coupon, partner Partner) bool {
+	if len(coupon.EligiblePartnerKeys) == 0 && len(coupon.EligiblePartnerGroups) == 0 && len(coupon.EligiblePartnerCategories) == 0 {
+		return true
+	}
+	return containsString(coupon.EligiblePartnerKeys, partner.Key) ||
+		(partner.Group != "" && containsString(coupon.EligiblePartnerGroups, strings.ToUpper(partner.Group))) ||
+		(partner.Category != "" && containsString(coupon.EligiblePartnerCategories, strings.ToUpper(partner.Category)))
+}
+
+//Function to explain which partners may accept the coupon
+func describePartnerIneligibility(coupon Coupon, partner Partner) string {
+	var rules []string
+	if len(coupon.EligiblePartnerKeys) > 0 {
+		rules = append(rules, fmt.Sprintf("partners %s", strings.Join(coupon.EligiblePartnerKeys, ", ")))
+	}
+	if len(coupon.EligiblePartnerGroups) > 0 {
+		rules = append(rules, fmt.Sprintf("groups %s", strings.Join(coupon.EligiblePartnerGroups, ", ")))
+	}
+	if len(coupon.EligiblePartnerCategories) > 0 {
+		rules = append(rules, fmt.Sprintf("categories %s", strings.Join(coupon.EligiblePartnerCategories, ", ")))
+	}
+	return fmt.Sprintf("Coupon %s is not accepted by partner %s (group %q, category %q), it is limited to %s",
+		coupon.Key, partner.Key, partner.Group, partner.Category, strings.Join(rules, " or "))
+}
+
+func normalizeList(values []string, normalize func(string) string) []string {
+	var normalized []string
+	for _, value := range values {
+		value = normalize(strings.TrimSpace(value))
+		if value != "" && !containsString(normalized, value) {
+			normalized = append(normalized, value)
+		}
+	}
+	return normalized
+}
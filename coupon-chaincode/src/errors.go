@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Every handler fails with a ChaincodeError serialized as the message of the error
+// response, so clients can act on Code instead of parsing the text. Field names the
+// offending argument field and Key the record concerned, when known. Errors
+// without a code, such as ledger failures, are reported as INTERNAL.
+const (
+	errCodeNotFound        = "NOT_FOUND"
+	errCodeInvalidArgument = "INVALID_ARGUMENT"
+	errCodeConflict        = "CONFLICT"
+	errCodeForbidden       = "FORBIDDEN"
+	errCodeExpired         = "EXPIRED"
+	errCodeAlreadyRedeemed = "ALREADY_REDEEMED"
+	errCodeInternal        = "INTERNAL"
+)
+
+type ChaincodeError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Field   string      `json:"field,omitempty"`
+	Key     string      `json:"key,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+func (e *ChaincodeError) Error() string {
+	return e.Message
+}
+
+func newChaincodeError(code string, key string, format string, args ...interface{}) *ChaincodeError {
+	return &ChaincodeError{Code: code, Message: fmt.Sprintf(format, args...), Key: key}
+}
+
+func notFoundError(key string, format string, args ...interface{}) *ChaincodeError {
+	return newChaincodeError(errCodeNotFound, key, format, args...)
+}
+
+func invalidArgumentError(field string, format string, args ...interface{}) *ChaincodeError {
+	chaincodeError := newChaincodeError(errCodeInvalidArgument, "", format, args...)
+	chaincodeError.Field = field
+	return chaincodeError
+}
+
+func conflictError(key string, format string, args ...interface{}) *ChaincodeError {
+	return newChaincodeError(errCodeConflict, key, format, args...)
+}
+
+func forbiddenError(key string, format string, args ...interface{}) *ChaincodeError {
+	return newChaincodeError(errCodeForbidden, key, format, args...)
+}
+
+//Function to set the record key of the error
+func (e *ChaincodeError) withKey(key string) *ChaincodeError {
+	e.Key = key
+	return e
+}
+
+//Function to set the offending argument field of the error
+func (e *ChaincodeError) withField(field string) *ChaincodeError {
+	e.Field = field
+	return e
+}
+
+//Function to set the details of the error
+func (e *ChaincodeError) withDetails(details interface{}) *ChaincodeError {
+	e.Details = details
+	return e
+}
+
+//Function to build the error response of a handler
+func errorResponse(err error) sc.Response {
+	chaincodeError, ok := err.(*ChaincodeError)
+	if !ok {
+		chaincodeError = &ChaincodeError{Code: errCodeInternal, Message: err.Error()}
+	}
+	errorAsBytes, _ := json.Marshal(chaincodeError)
+	return shim.Error(string(errorAsBytes))
+}
+
+// Error codes reported for the eligibility reasons of a rejected coupon
+var reasonErrorCodes = map[string]string{
+	reasonCouponNotFound:         errCodeNotFound,
+	reasonPartnerNotFound:        errCodeNotFound,
+	reasonAlreadyRedeemed:        errCodeAlreadyRedeemed,
+	reasonExpired:                errCodeExpired,
+	reasonCustomerMismatch:       errCodeForbidden,
+	reasonPartnerNotEligible:     errCodeForbidden,
+	reasonOutsideRegion:          errCodeForbidden,
+	reasonMinimumSpendNotMet:     errCodeInvalidArgument,
+	reasonDiscountExceedsPrice:   errCodeInvalidArgument,
+	reasonCustomerInactive:       errCodeConflict,
+	reasonPartnerSuspended:       errCodeConflict,
+	reasonInvalidStatus:          errCodeConflict,
+	reasonCampaignBudgetExceeded: errCodeConflict,
+}
+
+//Function to get the error code for an operation refused because of the coupon status
+func getStatusErrorCode(status string) string {
+	if status == couponStatusRedeemed {
+		return errCodeAlreadyRedeemed
+	}
+	return errCodeConflict
+}
+
+//Function to convert a rejected validation into an error carrying the response
+func rejectionError(key string, response ValidateCouponResponse) *ChaincodeError {
+	code, ok := reasonErrorCodes[response.Reason]
+	if !ok {
+		code = errCodeConflict
+	}
+	chaincodeError := newChaincodeError(code, key, "%s", response.Message).withDetails(response)
+	if code == errCodeInvalidArgument {
+		chaincodeError.Field = "assetOriginalPrice"
+	}
+	return chaincodeError
+}
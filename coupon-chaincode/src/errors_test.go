@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestCorruptRecordsFailAsInternalErrors(t *testing.T) {
+	ledger := newTestLedger(t, "2019-06-15T10:00:00Z")
+	ledger.stub.MockTransactionStart("corrupt")
+	for _, key := range []string{"salestransaction:corrupt", "customer:corrupt"} {
+		err := ledger.stub.PutState(key, []byte(`{"settlementBatchKey":`))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ledger.stub.MockTransactionEnd("corrupt")
+
+	tests := []struct {
+		caller  callerIdentity
+		fnc     string
+		request map[string]string
+	}{
+		{testAdmin, "deleterecord", map[string]string{"key": "salestransaction:corrupt"}},
+		{testAdmin, "querybykey", map[string]string{"key": "customer:corrupt"}},
+		{testIssuer, "querycouponsbycustomer", map[string]string{"key": "customer:corrupt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fnc, func(t *testing.T) {
+			response := ledger.invoke(tt.caller, tt.fnc, tt.request)
+			if response.Status == shim.OK {
+				t.Fatalf("%s succeeded on a corrupt record", tt.fnc)
+			}
+			var chaincodeError ChaincodeError
+			err := json.Unmarshal([]byte(response.Message), &chaincodeError)
+			if err != nil || chaincodeError.Code != errCodeInternal {
+				t.Errorf("%s error = %s, want code %s", tt.fnc, response.Message, errCodeInternal)
+			}
+		})
+	}
+	if state, _ := ledger.stub.GetState("salestransaction:corrupt"); state == nil {
+		t.Errorf("corrupt sales transaction was deleted")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/shopspring/decimal"
+)
+
+// Fabric keeps only the last event set by a transaction, so the changes of a
+// transaction are collected on the txStub and emitted together as one
+// EventBatch under couponEventName once the handler has succeeded.
+const (
+	couponEventName    = "CouponEvents"
+	eventSchemaVersion = "1.0"
+
+	eventCouponCreated           = "COUPON_CREATED"
+	eventCouponRedeemed          = "COUPON_REDEEMED"
+	eventCouponStatusChanged     = "COUPON_STATUS_CHANGED"
+	eventSalesTransactionCreated = "SALES_TRANSACTION_CREATED"
+	eventRecordDeleted           = "RECORD_DELETED"
+	eventCustomerStatusChanged   = "CUSTOMER_STATUS_CHANGED"
+	eventCouponTransferred       = "COUPON_TRANSFERRED"
+
+	eventSettlementBatchCreated       = "SETTLEMENT_BATCH_CREATED"
+	eventSettlementBatchStatusChanged = "SETTLEMENT_BATCH_STATUS_CHANGED"
+	eventRedemptionReversed           = "REDEMPTION_REVERSED"
+)
+
+type RecordEvent struct {
+	Type      string                     `json:"type"`
+	RecordKey string                     `json:"recordKey"`
+	OldStatus string                     `json:"oldStatus,omitempty"`
+	NewStatus string                     `json:"newStatus,omitempty"`
+	OldOwner  string                     `json:"oldOwner,omitempty"`
+	NewOwner  string                     `json:"newOwner,omitempty"`
+	Amounts   map[string]decimal.Decimal `json:"amounts,omitempty"`
+}
+
+type EventBatch struct {
+	Version string        `json:"version"`
+	TxID    string        `json:"txId"`
+	Events  []RecordEvent `json:"events"`
+}
+
+//Function to add an event to the batch of the transaction
+func addEvent(stub shim.ChaincodeStubInterface, event RecordEvent) {
+	if transaction, ok := stub.(*txStub); ok {
+		transaction.events = append(transaction.events, event)
+	}
+}
+
+//Function to emit the batched events of the transaction
+func emitEvents(transaction *txStub) error {
+	if len(transaction.events) == 0 {
+		return nil
+	}
+	eventBatch := EventBatch{
+		Version: eventSchemaVersion,
+		TxID:    transaction.GetTxID(),
+		Events:  transaction.events,
+	}
+	eventBatchAsBytes, _ := json.Marshal(eventBatch)
+	err := transaction.SetEvent(couponEventName, eventBatchAsBytes)
+	if err != nil {
+		return fmt.Errorf("Unable to set event %s error : %s", couponEventName, err.Error())
+	}
+	return nil
+}
+
+//Function to get the amounts of a sales transaction carried by events
+func salesTransactionAmounts(salesTransaction SalesTransaction) map[string]decimal.Decimal {
+	return map[string]decimal.Decimal{
+		"assetOriginalPrice": salesTransaction.AssetOriginalPrice,
+		"salesAmount":        salesTransaction.SalesAmount,
+		"revenueShareAmount": salesTransaction.RevenueShareAmount,
+		"settlementAmount":   salesTransaction.SettlementAmount,
+	}
+}
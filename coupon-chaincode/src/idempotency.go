@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Redemptions carrying a requestId are recorded under the partner and request ID
+// with a hash of the request. A retry with the same payload gets the recorded
+// response back without redeeming again; the same ID with another payload is a
+// conflict. Two attempts racing in the same block both write the record and the
+// coupon, so only one of them commits.
+const (
+	redeemIdempotencyIndex = "idempotency~redeem"
+	maxRequestIDLength     = 128
+)
+
+type RedemptionRecord struct {
+	RequestID   string               `json:"requestId"`
+	PayloadHash string               `json:"payloadHash"`
+	TxID        string               `json:"txId"`
+	Response    RedeemCouponResponse `json:"response"`
+}
+
+//Function to get the hash identifying the payload of a redeem request
+func hashRedeemCouponRequest(request RedeemCouponRequest) string {
+	payload := strings.Join([]string{
+		strings.ToLower(request.CouponKey),
+		strings.ToLower(request.CustomerKey),
+		strings.ToLower(request.PartnerKey),
+		request.AssetOriginalPrice.String(),
+	}, "|")
+	digest := sha256.Sum256([]byte(payload))
+	return hex.EncodeToString(digest[:])
+}
+
+func getRedemptionRecordKey(stub shim.ChaincodeStubInterface, request RedeemCouponRequest) (string, error) {
+	if len(request.RequestID) > maxRequestIDLength {
+		return "", invalidArgumentError("requestId", "Request ID must not exceed %d characters", maxRequestIDLength)
+	}
+	return stub.CreateCompositeKey(redeemIdempotencyIndex, []string{strings.ToLower(request.PartnerKey), request.RequestID})
+}
+
+//Function to get the recorded redemption of a request ID, a conflict is returned
+//when the ID was used for another payload
+func getRedemptionRecord(stub shim.ChaincodeStubInterface, request RedeemCouponRequest) (*RedemptionRecord, error) {
+	recordKey, err := getRedemptionRecordKey(stub, request)
+	if err != nil {
+		return nil, err
+	}
+	resultAsBytes, err := stub.GetState(recordKey)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to fetch redemption request %s error : %s", request.RequestID, err.Error())
+	}
+	if resultAsBytes == nil {
+		return nil, nil
+	}
+	var record RedemptionRecord
+	err = json.Unmarshal(resultAsBytes, &record)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid redemption record for request %s error : %s", request.RequestID, err.Error())
+	}
+	if record.PayloadHash != hashRedeemCouponRequest(request) {
+		return nil, conflictError("", "Conflict : request ID %s was already used for a different redemption", request.RequestID).withField("requestId")
+	}
+	return &record, nil
+}
+
+//Function to record the response of a redemption under its request ID
+func putRedemptionRecord(stub shim.ChaincodeStubInterface, request RedeemCouponRequest, response RedeemCouponResponse) error {
+	recordKey, err := getRedemptionRecordKey(stub, request)
+	if err != nil {
+		return err
+	}
+	record := RedemptionRecord{
+		RequestID:   request.RequestID,
+		PayloadHash: hashRedeemCouponRequest(request),
+		TxID:        stub.GetTxID(),
+		Response:    response,
+	}
+	recordAsBytes, _ := json.Marshal(record)
+	err = stub.PutState(recordKey, recordAsBytes)
+	if err != nil {
+		return fmt.Errorf("Unable to write redemption record for request %s error : %s", request.RequestID, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Secondary index of coupons by customer and status. The entries carry no value,
+// everything needed to find the coupon is part of the composite key. Coupons are
+// indexed under the derived status, so PARTIALLY_REDEEMED coupons can be listed.
+const customerCouponIndex = "customer~status~coupon"
+
+var indexEntryValue = []byte{0x00}
+
+//Function to add the coupon to the customer index
+func putCustomerCouponIndex(stub shim.ChaincodeStubInterface, coupon Coupon) error {
+	indexKey, err := stub.CreateCompositeKey(customerCouponIndex, []string{coupon.CustomerKey, getDerivedCouponStatus(coupon), coupon.Key})
+	if err != nil {
+		return fmt.Errorf("Unable to create index key for coupon %s error : %s", coupon.Key, err.Error())
+	}
+	err = stub.PutState(indexKey, indexEntryValue)
+	if err != nil {
+		return fmt.Errorf("Unable to write index for coupon %s error : %s", coupon.Key, err.Error())
+	}
+	return nil
+}
+
+//Function to remove the coupon from the customer index
+func delCustomerCouponIndex(stub shim.ChaincodeStubInterface, coupon Coupon) error {
+	return delCustomerCouponIndexEntry(stub, coupon, getDerivedCouponStatus(coupon))
+}
+
+func delCustomerCouponIndexEntry(stub shim.ChaincodeStubInterface, coupon Coupon, status string) error {
+	indexKey, err := stub.CreateCompositeKey(customerCouponIndex, []string{coupon.CustomerKey, status, coupon.Key})
+	if err != nil {
+		return fmt.Errorf("Unable to create index key for coupon %s error : %s", coupon.Key, err.Error())
+	}
+	err = stub.DelState(indexKey)
+	if err != nil {
+		return fmt.Errorf("Unable to delete index for coupon %s error : %s", coupon.Key, err.Error())
+	}
+	return nil
+}
+
+//Function to move the coupon index entry after a change of customer or status
+func updateCustomerCouponIndex(stub shim.ChaincodeStubInterface, previous Coupon, current Coupon) error {
+	if previous.CustomerKey == current.CustomerKey && getDerivedCouponStatus(previous) == getDerivedCouponStatus(current) {
+		return nil
+	}
+	err := delCustomerCouponIndex(stub, previous)
+	if err != nil {
+		return err
+	}
+	return putCustomerCouponIndex(stub, current)
+}
+
+//Function to get the coupon keys of a customer, optionally restricted to a status.
+//A page size of zero returns every key without pagination metadata.
+func getCustomerCouponKeys(stub shim.ChaincodeStubInterface, customerKey string, status string, pageSize int32, bookmark string) ([]string, *sc.QueryResponseMetadata, error) {
+	attributes := []string{customerKey}
+	if status != "" {
+		attributes = append(attributes, status)
+	}
+	var resultsIterator shim.StateQueryIteratorInterface
+	var metadata *sc.QueryResponseMetadata
+	var err error
+	if pageSize > 0 {
+		resultsIterator, metadata, err = stub.GetStateByPartialCompositeKeyWithPagination(customerCouponIndex, attributes, pageSize, bookmark)
+	} else {
+		resultsIterator, err = stub.GetStateByPartialCompositeKey(customerCouponIndex, attributes)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIterator.Close()
+	couponKeys := make([]string, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		couponKeys = append(couponKeys, keyParts[2])
+	}
+	return couponKeys, metadata, nil
+}
+
+//Function to rebuild the customer index for coupons written before the index existed,
+//entries of used multi-use coupons move from the stored to the derived status
+func (c *CouponChaincode) RebuildCouponIndex(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	err := parseArgs("rebuildcouponindex", args, nil)
+	if err != nil {
+		return errorResponse(err)
+	}
+	startRangeKey, endRangeKey := getRecordTypeRange(couponKeyPrefix)
+	resultsIterator, err := stub.GetStateByRange(startRangeKey, endRangeKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+	indexed := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		var coupon Coupon
+		err = json.Unmarshal(queryResponse.Value, &coupon)
+		if err != nil {
+			return errorResponse(fmt.Errorf("Invalid coupon record %s error : %s", queryResponse.Key, err.Error()))
+		}
+		coupon.Key = queryResponse.Key
+		coupon.CustomerKey = strings.ToLower(coupon.CustomerKey)
+		if coupon.Status != getDerivedCouponStatus(coupon) {
+			err = delCustomerCouponIndexEntry(stub, coupon, coupon.Status)
+			if err != nil {
+				return errorResponse(err)
+			}
+		}
+		err = putCustomerCouponIndex(stub, coupon)
+		if err != nil {
+			return errorResponse(err)
+		}
+		indexed++
+	}
+	return shim.Success([]byte(fmt.Sprintf("Indexed %d coupons", indexed)))
+}
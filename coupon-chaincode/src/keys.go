@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Namespace for the name based record keys generated by the chaincode.
+var recordKeyNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("coupon-chaincode/record-key"))
+
+// txStub wraps the stub of a single Invoke and carries state which must be shared
+// by every handler taking part in the transaction.
+type txStub struct {
+	shim.ChaincodeStubInterface
+	keySequence int
+	caller      callerIdentity
+	events      []RecordEvent
+}
+
+func newTxStub(stub shim.ChaincodeStubInterface) *txStub {
+	return &txStub{ChaincodeStubInterface: stub}
+}
+
+//Function to generate a new record key. The key is a name based UUID derived from
+//the transaction ID and the position of the record within the transaction, so every
+//endorser generates the same key without reading or writing a shared counter.
+func generateKey(stub shim.ChaincodeStubInterface, recordType string) string {
+	sequence := 0
+	if transaction, ok := stub.(*txStub); ok {
+		sequence = transaction.keySequence
+		transaction.keySequence++
+	}
+	name := fmt.Sprintf("%s/%s/%d", stub.GetTxID(), recordType, sequence)
+	return recordType + ":" + uuid.NewSHA1(recordKeyNamespace, []byte(name)).String()
+}
+
+//Function to get the key range covering every record of a record type
+func getRecordTypeRange(recordType string) (string, string) {
+	return recordType + ":", recordType + ";"
+}
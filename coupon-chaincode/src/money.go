@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+)
+
+// CurrencyConfig controls the scale and rounding applied to monetary amounts
+// of a currency. It is stored on the ledger under "currency:<code>" so that
+// the settings can be changed without upgrading the chaincode.
+type CurrencyConfig struct {
+	Key          string `json:"key,omitempty"`
+	Code         string `json:"code"`
+	Scale        int32  `json:"scale"`
+	RoundingMode string `json:"roundingMode"`
+}
+
+const (
+	currencyKeyPrefix   = "currency"
+	defaultCurrencyCode = "USD"
+	roundingHalfUp      = "HALF_UP"
+	roundingHalfEven    = "HALF_EVEN"
+	roundingDown        = "DOWN"
+	roundingUp          = "UP"
+	roundingFloor       = "FLOOR"
+	roundingCeiling     = "CEILING"
+	maxCurrencyScale    = 8
+)
+
+// Currencies known to the chaincode before any configuration is written.
+var defaultCurrencyConfigs = map[string]CurrencyConfig{
+	"USD": {Code: "USD", Scale: 2, RoundingMode: roundingHalfUp},
+	"EUR": {Code: "EUR", Scale: 2, RoundingMode: roundingHalfEven},
+	"GBP": {Code: "GBP", Scale: 2, RoundingMode: roundingHalfUp},
+	"INR": {Code: "INR", Scale: 2, RoundingMode: roundingHalfUp},
+	"JPY": {Code: "JPY", Scale: 0, RoundingMode: roundingHalfUp},
+}
+
+//Function to set the scale and rounding mode of a currency
+func (c *CouponChaincode) SetCurrencyConfig(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var currencyConfig CurrencyConfig
+	err := parseArgs("setcurrencyconfig", args, &currencyConfig)
+	if err != nil {
+		return errorResponse(err)
+	}
+	currencyConfig.Code = normalizeCurrencyCode(currencyConfig.Code)
+	currencyConfig.RoundingMode = strings.ToUpper(currencyConfig.RoundingMode)
+	if currencyConfig.Scale < 0 || currencyConfig.Scale > maxCurrencyScale {
+		return errorResponse(invalidArgumentError("scale", "Invalid scale %d for currency %s", currencyConfig.Scale, currencyConfig.Code))
+	}
+	if !isValidRoundingMode(currencyConfig.RoundingMode) {
+		return errorResponse(invalidArgumentError("roundingMode", "Invalid rounding mode %s for currency %s", currencyConfig.RoundingMode, currencyConfig.Code))
+	}
+	currencyConfig.Key = getCurrencyKey(currencyConfig.Code)
+	currencyConfigAsBytes, _ := json.Marshal(currencyConfig)
+	writeErr := stub.PutState(currencyConfig.Key, currencyConfigAsBytes)
+	if writeErr != nil {
+		return errorResponse(fmt.Errorf("Currency %s PutState failed : %s", currencyConfig.Key, writeErr.Error()))
+	}
+	return shim.Success(currencyConfigAsBytes)
+}
+
+//Function to get the currency config from the ledger, falling back to the built-in defaults
+func getCurrencyConfig(stub shim.ChaincodeStubInterface, code string) (CurrencyConfig, error) {
+	code = normalizeCurrencyCode(code)
+	resultAsBytes, err := stub.GetState(getCurrencyKey(code))
+	if err != nil {
+		return CurrencyConfig{}, fmt.Errorf("Unable to fetch currency %s error : %s", code, err.Error())
+	}
+	if resultAsBytes != nil {
+		var currencyConfig CurrencyConfig
+		err = json.Unmarshal(resultAsBytes, &currencyConfig)
+		if err != nil {
+			return CurrencyConfig{}, fmt.Errorf("Invalid currency config for %s error : %s", code, err.Error())
+		}
+		return currencyConfig, nil
+	}
+	currencyConfig, ok := defaultCurrencyConfigs[code]
+	if !ok {
+		return CurrencyConfig{}, invalidArgumentError("currency", "Unsupported currency : %s", code)
+	}
+	return currencyConfig, nil
+}
+
+//Function to round an amount to the scale of the currency using its rounding mode
+func (cfg CurrencyConfig) round(amount decimal.Decimal) decimal.Decimal {
+	switch cfg.RoundingMode {
+	case roundingHalfEven:
+		return amount.RoundBank(cfg.Scale)
+	case roundingDown:
+		return amount.Truncate(cfg.Scale)
+	case roundingUp:
+		if amount.Sign() < 0 {
+			return amount.Shift(cfg.Scale).Floor().Shift(-cfg.Scale)
+		}
+		return amount.Shift(cfg.Scale).Ceil().Shift(-cfg.Scale)
+	case roundingFloor:
+		return amount.Shift(cfg.Scale).Floor().Shift(-cfg.Scale)
+	case roundingCeiling:
+		return amount.Shift(cfg.Scale).Ceil().Shift(-cfg.Scale)
+	default:
+		return amount.Round(cfg.Scale)
+	}
+}
+
+//Function to apply a percentage to an amount without rounding
+func percentOf(amount decimal.Decimal, percent decimal.Decimal) decimal.Decimal {
+	return amount.Mul(percent).Shift(-2)
+}
+
+func isValidRoundingMode(roundingMode string) bool {
+	switch roundingMode {
+	case roundingHalfUp, roundingHalfEven, roundingDown, roundingUp, roundingFloor, roundingCeiling:
+		return true
+	}
+	return false
+}
+
+func normalizeCurrencyCode(code string) string {
+	if code == "" {
+		return defaultCurrencyCode
+	}
+	return strings.ToUpper(code)
+}
+
+func getCurrencyKey(code string) string {
+	return currencyKeyPrefix + ":" + strings.ToLower(code)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCurrencyConfigRound(t *testing.T) {
+	tests := []struct {
+		name         string
+		scale        int32
+		roundingMode string
+		amount       string
+		want         string
+	}{
+		{"half up rounds half away from zero", 2, roundingHalfUp, "1.005", "1.01"},
+		{"half up negative", 2, roundingHalfUp, "-1.005", "-1.01"},
+		{"half even rounds half to even down", 2, roundingHalfEven, "1.005", "1.00"},
+		{"half even rounds half to even up", 2, roundingHalfEven, "1.015", "1.02"},
+		{"down truncates", 2, roundingDown, "1.019", "1.01"},
+		{"down truncates negative towards zero", 2, roundingDown, "-1.019", "-1.01"},
+		{"up rounds away from zero", 2, roundingUp, "1.011", "1.02"},
+		{"up rounds negative away from zero", 2, roundingUp, "-1.011", "-1.02"},
+		{"floor rounds negative down", 2, roundingFloor, "-1.011", "-1.02"},
+		{"ceiling rounds negative up", 2, roundingCeiling, "-1.019", "-1.01"},
+		{"zero scale", 0, roundingHalfUp, "2.5", "3"},
+		{"unknown mode falls back to half up", 2, "", "1.005", "1.01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CurrencyConfig{Code: "TST", Scale: tt.scale, RoundingMode: tt.roundingMode}
+			got := cfg.round(decimal.RequireFromString(tt.amount))
+			if !got.Equal(decimal.RequireFromString(tt.want)) {
+				t.Errorf("round(%s) = %s, want %s", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPercentOf(t *testing.T) {
+	tests := []struct {
+		amount  string
+		percent string
+		want    string
+	}{
+		{"200", "12.5", "25"},
+		{"33.33", "15", "4.9995"},
+		{"100", "0", "0"},
+		{"100", "100", "100"},
+		{"-80", "10", "-8"},
+	}
+	for _, tt := range tests {
+		got := percentOf(decimal.RequireFromString(tt.amount), decimal.RequireFromString(tt.percent))
+		if !got.Equal(decimal.RequireFromString(tt.want)) {
+			t.Errorf("percentOf(%s, %s) = %s, want %s", tt.amount, tt.percent, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// PagedQueryResponse is returned by the queries when a page size or bookmark is
+// supplied. Passing Bookmark back with the next request fetches the next page;
+// the last page is reached when FetchedRecordsCount is smaller than the page size.
+type PagedQueryResponse struct {
+	Records             json.RawMessage `json:"records"`
+	FetchedRecordsCount int32           `json:"fetchedRecordsCount"`
+	Bookmark            string          `json:"bookmark"`
+}
+
+const maxPageSize int32 = 1000
+
+//Function to check the requested page size
+func validatePageSize(pageSize int32) error {
+	if pageSize <= 0 || pageSize > maxPageSize {
+		return invalidArgumentError("pageSize", "Invalid page size %d, expected a value between 1 and %d", pageSize, maxPageSize)
+	}
+	return nil
+}
+
+//Function to get a page of results based on range
+func getStatebyRangeResultWithPagination(stub shim.ChaincodeStubInterface, startRangeKey string, endRangeKey string, pageSize int32, bookmark string) ([]byte, error) {
+	err := validatePageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, metadata, err := stub.GetStateByRangeWithPagination(startRangeKey, endRangeKey, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	records, err := generateQueryRecords(resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+	return newPagedQueryResponse(records, metadata), nil
+}
+
+//Function to wrap a page of records with the pagination metadata
+func newPagedQueryResponse(records []byte, metadata *sc.QueryResponseMetadata) []byte {
+	pagedQueryResponse := PagedQueryResponse{Records: records}
+	if metadata != nil {
+		pagedQueryResponse.FetchedRecordsCount = metadata.FetchedRecordsCount
+		pagedQueryResponse.Bookmark = metadata.Bookmark
+	}
+	pagedQueryResponseAsBytes, _ := json.Marshal(pagedQueryResponse)
+	return pagedQueryResponseAsBytes
+}
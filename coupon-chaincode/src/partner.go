@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	partnerStatusActive    = "ACTIVE"
+	partnerStatusSuspended = "SUSPENDED"
+	// Index of the partners located at an address, used to protect referenced
+	// addresses from deletion
+	addressPartnerIndex = "address~partner"
+)
+
+//Function to register a partner at an existing address
+func (c *CouponChaincode) RegisterPartner(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var partner Partner
+	err := parseArgs("registerpartner", args, &partner)
+	if err != nil {
+		return errorResponse(err)
+	}
+	partner.Key = generateKey(stub, partnerKeyPrefix)
+	partner.Status = partnerStatusActive
+	err = validatePartner(stub, &partner)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = putAddressPartnerIndex(stub, partner)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return putPartner(stub, partner)
+}
+
+//Function to update the name and address of a partner
+func (c *CouponChaincode) UpdatePartner(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var partner Partner
+	err := parseArgs("updatepartner", args, &partner)
+	if err != nil {
+		return errorResponse(err)
+	}
+	previousPartner, found, err := getPartner(stub, partner.Key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !found {
+		return errorResponse(notFoundError(partner.Key, "Partner %s does not exist", partner.Key))
+	}
+	partner.Key = previousPartner.Key
+	partner.Status = previousPartner.Status
+	err = validatePartner(stub, &partner)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if partner.AddressKey != previousPartner.AddressKey {
+		err = delAddressPartnerIndex(stub, previousPartner)
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = putAddressPartnerIndex(stub, partner)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	return putPartner(stub, partner)
+}
+
+//Function to suspend a partner, a suspended partner can not redeem coupons
+func (c *CouponChaincode) SuspendPartner(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var queryKey QueryKey
+	err := parseArgs("suspendpartner", args, &queryKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	partner, found, err := getPartner(stub, queryKey.Key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !found {
+		return errorResponse(notFoundError(queryKey.Key, "Partner %s does not exist", queryKey.Key))
+	}
+	if partner.Status == partnerStatusSuspended {
+		return errorResponse(conflictError(partner.Key, "Partner %s is already suspended", partner.Key))
+	}
+	partner.Status = partnerStatusSuspended
+	return putPartner(stub, partner)
+}
+
+//Function to get a partner
+func getPartner(stub shim.ChaincodeStubInterface, partnerKey string) (Partner, bool, error) {
+	var partner Partner
+	partnerKey = strings.ToLower(partnerKey)
+	resultAsBytes, err := stub.GetState(partnerKey)
+	if err != nil {
+		return partner, false, fmt.Errorf("Unable to fetch partner %s error : %s", partnerKey, err.Error())
+	}
+	if resultAsBytes == nil {
+		return partner, false, nil
+	}
+	err = json.Unmarshal(resultAsBytes, &partner)
+	if err != nil {
+		return partner, false, fmt.Errorf("Invalid partner record %s error : %s", partnerKey, err.Error())
+	}
+	partner.Key = partnerKey
+	if partner.Status == "" {
+		partner.Status = partnerStatusActive
+	}
+	return partner, true, nil
+}
+
+func putPartner(stub shim.ChaincodeStubInterface, partner Partner) sc.Response {
+	partnerAsBytes, _ := json.Marshal(partner)
+	writeErr := stub.PutState(partner.Key, partnerAsBytes)
+	if writeErr != nil {
+		return errorResponse(fmt.Errorf("Partner %s PutState failed: %s", partner.Key, writeErr.Error()))
+	}
+	return shim.Success(partnerAsBytes)
+}
+
+//Function to validate the partner fields and check that its address exists
+func validatePartner(stub shim.ChaincodeStubInterface, partner *Partner) error {
+	partner.Name = strings.TrimSpace(partner.Name)
+	if partner.Name == "" {
+		return invalidArgumentError("name", "Partner name is required")
+	}
+	partner.Group = strings.ToUpper(strings.TrimSpace(partner.Group))
+	partner.Category = strings.ToUpper(strings.TrimSpace(partner.Category))
+	partner.AddressKey = strings.ToLower(partner.AddressKey)
+	_, found, err := getAddress(stub, partner.AddressKey)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return notFoundError(partner.AddressKey, "Address %s of partner %s does not exist", partner.AddressKey, partner.Name).withField("addressKey")
+	}
+	return nil
+}
+
+func putAddressPartnerIndex(stub shim.ChaincodeStubInterface, partner Partner) error {
+	indexKey, err := stub.CreateCompositeKey(addressPartnerIndex, []string{partner.AddressKey, partner.Key})
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(indexKey, indexEntryValue)
+	if err != nil {
+		return fmt.Errorf("Unable to write address index for partner %s error : %s", partner.Key, err.Error())
+	}
+	return nil
+}
+
+func delAddressPartnerIndex(stub shim.ChaincodeStubInterface, partner Partner) error {
+	indexKey, err := stub.CreateCompositeKey(addressPartnerIndex, []string{partner.AddressKey, partner.Key})
+	if err != nil {
+		return err
+	}
+	err = stub.DelState(indexKey)
+	if err != nil {
+		return fmt.Errorf("Unable to delete address index for partner %s error : %s", partner.Key, err.Error())
+	}
+	return nil
+}
+
+//Function to check whether any partner is located at the address
+func isAddressReferenced(stub shim.ChaincodeStubInterface, addressKey string) (bool, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(addressPartnerIndex, []string{addressKey})
+	if err != nil {
+		return false, err
+	}
+	defer resultsIterator.Close()
+	return resultsIterator.HasNext(), nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestInitPartnersKeepsSuspendedPartner(t *testing.T) {
+	ledger := newTestLedger(t, "2019-06-15T10:00:00Z")
+	seed := func(txID string) {
+		ledger.stub.MockTransactionStart(txID)
+		defer ledger.stub.MockTransactionEnd(txID)
+		err := ledger.cc.initAddresses(ledger.stub)
+		if err != nil {
+			t.Fatalf("initAddresses failed : %s", err.Error())
+		}
+		err = ledger.cc.initPartners(ledger.stub)
+		if err != nil {
+			t.Fatalf("initPartners failed : %s", err.Error())
+		}
+	}
+	seed("init")
+	ledger.mustInvoke(testAdmin, "suspendpartner", map[string]string{"key": "partner:101"}, nil)
+	seed("upgrade")
+
+	partner, found, err := getPartner(ledger.stub, "partner:101")
+	if err != nil || !found {
+		t.Fatalf("partner:101 not found : %v", err)
+	}
+	if partner.Status != partnerStatusSuspended {
+		t.Errorf("partner status after upgrade = %s, want %s", partner.Status, partnerStatusSuspended)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Customer name and email are kept in the customerPIICollection private data
+// collection. The public customer record only carries a salted hash of the PII so
+// members can prove what was stored without revealing it. The collection is
+// defined with memberOnlyRead, so reads by clients of other orgs fail and the
+// public record is returned on its own.
+const (
+	customerPIICollection = "collectionCustomerPII"
+	customerTransientKey  = "customer"
+	minimumSaltLength     = 16
+	// Message of the peer when a client outside a memberOnlyRead collection reads it
+	privateDataAccessDeniedMessage = "does not have read access permission on privatedata"
+)
+
+// The email index is keyed by an HMAC of the email. The key of a private data
+// entry is hashed onto the public ledger, so a key holding the email itself could
+// be matched against guessed emails. The secret is kept in the collection.
+const (
+	emailIndexSecretKey           = "config:emailindexsecret"
+	emailIndexSecretTransientKey  = "emailIndexSecret"
+	minimumEmailIndexSecretLength = 32
+)
+
+// CustomerPII is supplied through the transient map under "customer" and stored
+// in the private data collection under the customer key.
+type CustomerPII struct {
+	Key   string `json:"key,omitempty"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Salt  string `json:"salt"`
+}
+
+//Function to read the customer PII from the transient map of the proposal
+func getCustomerPIIFromTransient(stub shim.ChaincodeStubInterface) (CustomerPII, error) {
+	var customerPII CustomerPII
+	transientMap, err := stub.GetTransient()
+	if err != nil {
+		return customerPII, fmt.Errorf("Unable to read transient map error : %s", err.Error())
+	}
+	piiAsBytes, ok := transientMap[customerTransientKey]
+	if !ok || len(piiAsBytes) == 0 {
+		return customerPII, invalidArgumentError("customer", "Customer PII must be passed in the transient map under %q", customerTransientKey)
+	}
+	err = json.Unmarshal(piiAsBytes, &customerPII)
+	if err != nil {
+		return customerPII, invalidArgumentError("customer", "Invalid customer PII error : %s", err.Error())
+	}
+	customerPII.Email = strings.ToLower(strings.TrimSpace(customerPII.Email))
+	customerPII.Name = strings.TrimSpace(customerPII.Name)
+	if len(customerPII.Salt) < minimumSaltLength {
+		return customerPII, invalidArgumentError("salt", "Customer PII salt must be at least %d characters", minimumSaltLength)
+	}
+	return customerPII, nil
+}
+
+//Function to write the customer PII to the private data collection
+func putCustomerPII(stub shim.ChaincodeStubInterface, customerPII CustomerPII) error {
+	piiAsBytes, _ := json.Marshal(customerPII)
+	err := stub.PutPrivateData(customerPIICollection, customerPII.Key, piiAsBytes)
+	if err != nil {
+		return fmt.Errorf("Customer %s PutPrivateData failed : %s", customerPII.Key, err.Error())
+	}
+	return nil
+}
+
+//Function to get the customer PII, returning false when none is stored. Reads fail
+//when the caller or peer is not a member of the collection.
+func getCustomerPII(stub shim.ChaincodeStubInterface, customerKey string) (CustomerPII, bool, error) {
+	var customerPII CustomerPII
+	piiAsBytes, err := stub.GetPrivateData(customerPIICollection, customerKey)
+	if err != nil {
+		return customerPII, false, fmt.Errorf("Unable to read PII of customer %s error : %s", customerKey, err.Error())
+	}
+	if piiAsBytes == nil {
+		return customerPII, false, nil
+	}
+	err = json.Unmarshal(piiAsBytes, &customerPII)
+	if err != nil {
+		return customerPII, false, fmt.Errorf("Invalid PII of customer %s error : %s", customerKey, err.Error())
+	}
+	return customerPII, true, nil
+}
+
+//Function to get the salted hash of the customer PII kept on the public ledger
+func hashCustomerPII(customerPII CustomerPII) string {
+	digest := sha256.Sum256([]byte(customerPII.Salt + "|" + customerPII.Name + "|" + customerPII.Email))
+	return hex.EncodeToString(digest[:])
+}
+
+//Function to add the PII to a public customer record when the caller may read it,
+//callers outside the collection get the public record on its own
+func withCustomerPII(stub shim.ChaincodeStubInterface, customer Customer) (Customer, error) {
+	customerPII, ok, err := getCustomerPII(stub, customer.Key)
+	if err != nil {
+		if isPrivateDataAccessDenied(err) {
+			return customer, nil
+		}
+		return customer, err
+	}
+	if ok {
+		customer.Name = customerPII.Name
+		customer.Email = customerPII.Email
+	}
+	return customer, nil
+}
+
+//Function to check whether a private data read failed because the client of the
+//proposal is not a member of the collection. The peer only reports it in the message.
+func isPrivateDataAccessDenied(err error) bool {
+	return strings.Contains(err.Error(), privateDataAccessDeniedMessage)
+}
+
+//Function to get the secret keying the email index, empty until one has been set
+func getEmailIndexSecret(stub shim.ChaincodeStubInterface) (string, error) {
+	secretAsBytes, err := stub.GetPrivateData(customerPIICollection, emailIndexSecretKey)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read email index secret error : %s", err.Error())
+	}
+	return string(secretAsBytes), nil
+}
+
+//Function to read a new email index secret from the transient map, returning false
+//when none was passed
+func getEmailIndexSecretFromTransient(stub shim.ChaincodeStubInterface) (string, bool, error) {
+	transientMap, err := stub.GetTransient()
+	if err != nil {
+		return "", false, fmt.Errorf("Unable to read transient map error : %s", err.Error())
+	}
+	secretAsBytes, ok := transientMap[emailIndexSecretTransientKey]
+	if !ok || len(secretAsBytes) == 0 {
+		return "", false, nil
+	}
+	if len(secretAsBytes) < minimumEmailIndexSecretLength {
+		return "", false, invalidArgumentError(emailIndexSecretTransientKey, "Email index secret must be at least %d characters", minimumEmailIndexSecretLength)
+	}
+	return string(secretAsBytes), true, nil
+}
+
+//Function to get the HMAC of the normalised email
+func hashEmail(secret string, email string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+type privateDataErrorStub struct {
+	*shim.MockStub
+	err error
+}
+
+func (stub privateDataErrorStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	return nil, stub.err
+}
+
+func TestWithCustomerPIIOnlyIgnoresAccessDenied(t *testing.T) {
+	customer := Customer{Key: "customer:alice"}
+	denied := privateDataErrorStub{shim.NewMockStub("coupon", nil), errors.New("tx creator does not have read access permission on privatedata in chaincodeName:coupon collectionName: " + customerPIICollection)}
+	if got, err := withCustomerPII(denied, customer); err != nil || got.Key != customer.Key {
+		t.Errorf("withCustomerPII with access denied = %+v, %v, want the public record", got, err)
+	}
+	failing := privateDataErrorStub{shim.NewMockStub("coupon", nil), errors.New("private data is not available")}
+	if _, err := withCustomerPII(failing, customer); err == nil {
+		t.Errorf("withCustomerPII ignored a failed private data read")
+	}
+}
+
+func TestQueryCouponsByCustomerShapes(t *testing.T) {
+	ledger := newTestLedger(t, "2019-06-15T10:00:00Z")
+	customerKey := "customer:alice"
+	ledger.seedPartnerAndCustomer(customerKey)
+	couponKey := ledger.createCoupon(map[string]string{
+		"name":                "Summer Sale",
+		"expiresOn":           "31-12-2019",
+		"discountAmount":      "10",
+		"revenueSharePercent": "10",
+		"customerKey":         customerKey,
+	})
+
+	var coupons []Coupon
+	ledger.mustInvoke(testIssuer, "querycouponsbycustomer", map[string]string{"key": customerKey}, &coupons)
+	if len(coupons) != 1 || coupons[0].Key != couponKey {
+		t.Errorf("querycouponsbycustomer = %+v, want the array with %s", coupons, couponKey)
+	}
+
+	var customerCoupon CustomerCoupon
+	ledger.mustInvoke(testIssuer, "querycustomercoupons", map[string]string{"key": customerKey}, &customerCoupon)
+	if customerCoupon.Customer.Key != customerKey || len(customerCoupon.Coupons) != 1 || customerCoupon.Coupons[0].Key != couponKey {
+		t.Errorf("querycustomercoupons = %+v, want customer %s with %s", customerCoupon, customerKey, couponKey)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const reasonOutsideRegion = "OUTSIDE_ALLOWED_REGION"
+
+// Region limits a coupon to partners whose address is in the country and, when
+// set, in the state and under the zip code prefix.
+type Region struct {
+	Country   string `json:"country"`
+	State     string `json:"state,omitempty"`
+	ZipPrefix string `json:"zipPrefix,omitempty"`
+}
+
+//Function to normalize the allowed regions of a new coupon the same way as addresses
+func normalizeRegions(coupon *Coupon) error {
+	for i, region := range coupon.AllowedRegions {
+		country := normalizeCountry(region.Country)
+		if country == "" {
+			return invalidArgumentError("allowedRegions", "Allowed region %d must have a country", i)
+		}
+		region.Country = country
+		region.State = strings.TrimSpace(region.State)
+		if rules, ok := countryAddressRules[country]; ok && rules.States != nil && region.State != "" {
+			state, ok := findState(rules.States, region.State)
+			if !ok {
+				return invalidArgumentError("allowedRegions", "Invalid state %s for country %s in allowed region %d", region.State, country, i)
+			}
+			region.State = state
+		}
+		region.ZipPrefix = strings.ToUpper(strings.TrimSpace(region.ZipPrefix))
+		coupon.AllowedRegions[i] = region
+	}
+	return nil
+}
+
+//Function to check whether the address is in one of the regions. Addresses written
+//before they were normalized may spell the country, state or zip code differently,
+//so they are normalized again before the comparison.
+func isAddressInRegions(address Address, regions []Region) bool {
+	country := normalizeCountry(address.Country)
+	state := strings.TrimSpace(address.State)
+	if rules, ok := countryAddressRules[country]; ok && rules.States != nil {
+		if name, ok := findState(rules.States, state); ok {
+			state = name
+		}
+	}
+	zipCode := strings.ToUpper(strings.TrimSpace(address.ZipCode))
+	for _, region := range regions {
+		if country != region.Country {
+			continue
+		}
+		if region.State != "" && !strings.EqualFold(state, region.State) {
+			continue
+		}
+		if region.ZipPrefix != "" && !strings.HasPrefix(zipCode, region.ZipPrefix) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+//Function to check the address of the partner against the allowed regions of the
+//coupon, returning the reason message when it is outside them
+func checkPartnerRegion(stub shim.ChaincodeStubInterface, coupon Coupon, partner Partner) (string, error) {
+	if len(coupon.AllowedRegions) == 0 {
+		return "", nil
+	}
+	address, found, err := getAddress(stub, partner.AddressKey)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return fmt.Sprintf("Address %s of partner %s does not exist, coupon %s is limited to %s", partner.AddressKey, partner.Key, coupon.Key, describeRegions(coupon.AllowedRegions)), nil
+	}
+	if !isAddressInRegions(address, coupon.AllowedRegions) {
+		return fmt.Sprintf("Partner %s at %s, %s %s is outside the regions of coupon %s : %s", partner.Key, address.State, address.ZipCode, address.Country, coupon.Key, describeRegions(coupon.AllowedRegions)), nil
+	}
+	return "", nil
+}
+
+func describeRegions(regions []Region) string {
+	var descriptions []string
+	for _, region := range regions {
+		description := region.Country
+		if region.State != "" {
+			description += "/" + region.State
+		}
+		if region.ZipPrefix != "" {
+			description += "/" + region.ZipPrefix + "*"
+		}
+		descriptions = append(descriptions, description)
+	}
+	return strings.Join(descriptions, ", ")
+}
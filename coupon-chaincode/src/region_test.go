@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestIsAddressInRegions(t *testing.T) {
+	coupon := Coupon{AllowedRegions: []Region{
+		{Country: "US", State: "PA"},
+		{Country: "Canada", ZipPrefix: "m5v"},
+	}}
+	err := normalizeRegions(&coupon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		address Address
+		want    bool
+	}{
+		{"normalized address", Address{ZipCode: "19003", State: "Pennsylvania", Country: "USA"}, true},
+		{"legacy alpha-2 country and state code", Address{ZipCode: "19003", State: "pa", Country: "us"}, true},
+		{"legacy country name", Address{ZipCode: "19003", State: "Pennsylvania", Country: "United States"}, true},
+		{"other state", Address{ZipCode: "10001", State: "NY", Country: "US"}, false},
+		{"legacy lower case zip code", Address{ZipCode: "m5v 2t6", State: "ON", Country: "ca"}, true},
+		{"zip code outside the prefix", Address{ZipCode: "K1A 0B1", State: "ON", Country: "CAN"}, false},
+		{"other country", Address{ZipCode: "SW1A 1AA", State: "London", Country: "UK"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAddressInRegions(tt.address, coupon.AllowedRegions); got != tt.want {
+				t.Errorf("isAddressInRegions(%+v) = %v, want %v", tt.address, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+)
+
+// ReverseRedemptionRequest refunds RefundAmount of the original price of a
+// redemption, the whole price when it is not set. The refund is booked as a new
+// sales transaction with negated amounts, so settled totals are never rewritten.
+type ReverseRedemptionRequest struct {
+	SalesTransactionKey string           `json:"salesTransactionKey"`
+	RefundAmount        *decimal.Decimal `json:"refundAmount,omitempty"`
+}
+
+//Function to reverse all or part of a redemption with a compensating sales transaction
+func (c *CouponChaincode) ReverseRedemption(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var request ReverseRedemptionRequest
+	err := parseArgs("reverseredemption", args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+	original, found, err := getSalesTransaction(stub, request.SalesTransactionKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !found {
+		return errorResponse(notFoundError(strings.ToLower(request.SalesTransactionKey), "Sales transaction %s does not exist", strings.ToLower(request.SalesTransactionKey)))
+	}
+	if original.ReversalOf != "" || original.CouponKey == "" {
+		return errorResponse(invalidArgumentError("salesTransactionKey", "Sales transaction %s is not a redemption", original.Key).withKey(original.Key))
+	}
+	caller := getCaller(stub)
+	if caller.Role == rolePartner && caller.PartnerKey != original.PartnerKey {
+		return errorResponse(forbiddenError(original.Key, "Access denied : partner %s may not reverse sales transaction %s", caller.PartnerKey, original.Key))
+	}
+	if original.SettlementBatchKey != "" {
+		settlementBatch, found, err := getSettlementBatch(stub, original.SettlementBatchKey)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if found && settlementBatch.Status == settlementStatusPaid {
+			return errorResponse(conflictError(original.Key, "Sales transaction %s is in paid settlement batch %s and can not be reversed", original.Key, settlementBatch.Key))
+		}
+	}
+	currencyConfig, err := getCurrencyConfig(stub, original.Currency)
+	if err != nil {
+		return errorResponse(err)
+	}
+	refundableAmount := original.AssetOriginalPrice.Sub(original.RefundedAmount)
+	refundAmount := refundableAmount
+	if request.RefundAmount != nil {
+		refundAmount = currencyConfig.round(*request.RefundAmount)
+	}
+	if !refundAmount.IsPositive() {
+		return errorResponse(conflictError(original.Key, "Sales transaction %s has nothing left to refund", original.Key))
+	}
+	if refundAmount.GreaterThan(refundableAmount) {
+		return errorResponse(invalidArgumentError("refundAmount", "Refund of %s exceeds the %s left to refund on sales transaction %s", refundAmount, refundableAmount, original.Key).withKey(original.Key))
+	}
+	now, err := c.clock(stub).Now()
+	if err != nil {
+		return errorResponse(err)
+	}
+	reversal := prepReversalSalesTransaction(original, refundAmount, currencyConfig)
+	reversal.CreatedDateTime = now.Format(dateTimeFormat)
+	err = putNewSalesTransaction(stub, &reversal)
+	if err != nil {
+		return errorResponse(err)
+	}
+	original.RefundedAmount = original.RefundedAmount.Add(refundAmount)
+	original.ReversalKeys = append(original.ReversalKeys, reversal.Key)
+	originalAsBytes, _ := json.Marshal(original)
+	writeErr := stub.PutState(original.Key, originalAsBytes)
+	if writeErr != nil {
+		return errorResponse(fmt.Errorf("SalesTransaction %s PutState failed: %s", original.Key, writeErr.Error()))
+	}
+	isFullRefund := original.RefundedAmount.Equal(original.AssetOriginalPrice)
+	refundedDiscount := reversal.DiscountAmount.Neg()
+	coupon, found, err := getCoupon(stub, original.CouponKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	reversedEvent := RecordEvent{
+		Type:      eventRedemptionReversed,
+		RecordKey: original.Key,
+		Amounts:   salesTransactionAmounts(reversal),
+	}
+	if found {
+		hasExpired, err := hasCouponExpired(coupon.ExpiresOn, coupon.TimeZone, now)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if !hasExpired {
+			restoredCoupon := restoreCouponUse(coupon, refundedDiscount, isFullRefund)
+			couponAsBytes, _ := json.Marshal(restoredCoupon)
+			writeErr := stub.PutState(coupon.Key, couponAsBytes)
+			if writeErr != nil {
+				return errorResponse(fmt.Errorf("Coupon %s PutState failed: %s", coupon.Key, writeErr.Error()))
+			}
+			err = updateCustomerCouponIndex(stub, coupon, restoredCoupon)
+			if err != nil {
+				return errorResponse(err)
+			}
+			reversedEvent.OldStatus = getDerivedCouponStatus(coupon)
+			reversedEvent.NewStatus = getDerivedCouponStatus(restoredCoupon)
+		}
+		redemptions := 0
+		if isFullRefund {
+			redemptions = -1
+		}
+		err = recordCampaignRedemption(stub, coupon, redemptions, refundedDiscount.Neg())
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	addEvent(stub, reversedEvent)
+	reversalAsBytes, _ := json.Marshal(reversal)
+	return shim.Success(reversalAsBytes)
+}
+
+//Function to build the compensating sales transaction for a refund. The amounts
+//reversed so far are derived from the total refunded, so the reversals of a
+//fully refunded transaction add up to its amounts exactly.
+func prepReversalSalesTransaction(original SalesTransaction, refundAmount decimal.Decimal, currencyConfig CurrencyConfig) SalesTransaction {
+	previousShare := original.RefundedAmount.Div(original.AssetOriginalPrice)
+	share := original.RefundedAmount.Add(refundAmount).Div(original.AssetOriginalPrice)
+	reversedPart := func(amount decimal.Decimal) decimal.Decimal {
+		reversedTotal := currencyConfig.round(amount.Mul(share))
+		if share.Equal(decimal.New(1, 0)) {
+			reversedTotal = amount
+		}
+		return reversedTotal.Sub(currencyConfig.round(amount.Mul(previousShare)))
+	}
+	discountAmount := reversedPart(original.DiscountAmount)
+	revenueShareAmount := reversedPart(original.RevenueShareAmount)
+	salesAmount := refundAmount.Sub(discountAmount)
+	settlementAmount := salesAmount.Sub(revenueShareAmount)
+	reversal := SalesTransaction{
+		PartnerKey:          original.PartnerKey,
+		CouponKey:           original.CouponKey,
+		ReversalOf:          original.Key,
+		AssetOriginalPrice:  refundAmount.Neg(),
+		DiscountAmount:      discountAmount.Neg(),
+		SalesAmount:         salesAmount.Neg(),
+		RevenueShareAmount:  revenueShareAmount.Neg(),
+		SettlementAmount:    settlementAmount.Neg(),
+		RevenueSharePercent: original.RevenueSharePercent,
+		ShareBasis:          original.ShareBasis,
+		ContractKey:         original.ContractKey,
+		ContractVersion:     original.ContractVersion,
+		Currency:            original.Currency,
+	}
+	return reversal
+}
+
+//Function to get a sales transaction
+func getSalesTransaction(stub shim.ChaincodeStubInterface, salesTransactionKey string) (SalesTransaction, bool, error) {
+	var salesTransaction SalesTransaction
+	salesTransactionKey = strings.ToLower(salesTransactionKey)
+	resultAsBytes, err := stub.GetState(salesTransactionKey)
+	if err != nil {
+		return salesTransaction, false, fmt.Errorf("Unable to fetch sales transaction %s error : %s", salesTransactionKey, err.Error())
+	}
+	if resultAsBytes == nil {
+		return salesTransaction, false, nil
+	}
+	err = json.Unmarshal(resultAsBytes, &salesTransaction)
+	if err != nil {
+		return salesTransaction, false, fmt.Errorf("Invalid sales transaction record %s error : %s", salesTransactionKey, err.Error())
+	}
+	salesTransaction.Key = salesTransactionKey
+	salesTransaction.PartnerKey = strings.ToLower(salesTransaction.PartnerKey)
+	return salesTransaction, true, nil
+}
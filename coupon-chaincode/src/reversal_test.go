@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestReverseRedemptionThenSettle(t *testing.T) {
+	ledger := newTestLedger(t, "2019-06-15T10:00:00Z")
+	customerKey := "customer:alice"
+	partner := ledger.seedPartnerAndCustomer(customerKey)
+	couponKey := ledger.createCoupon(map[string]string{
+		"name":                "Summer Sale",
+		"expiresOn":           "31-12-2019",
+		"discountAmount":      "10",
+		"revenueSharePercent": "10",
+		"customerKey":         customerKey,
+	})
+	sale := ledger.mustRedeem(partner, couponKey, customerKey, "100").SalesTransaction
+
+	var reversal SalesTransaction
+	ledger.mustInvoke(partner, "reverseredemption", map[string]string{"salesTransactionKey": sale.Key}, &reversal)
+	if reversal.ReversalOf != sale.Key {
+		t.Errorf("reversal of = %s, want %s", reversal.ReversalOf, sale.Key)
+	}
+	assertAmount(t, "reversed discount", reversal.DiscountAmount, "-10")
+	assertAmount(t, "reversed sales amount", reversal.SalesAmount, "-90")
+	assertAmount(t, "reversed settlement amount", reversal.SettlementAmount, "-80")
+	if coupon := ledger.getCoupon(couponKey); coupon.Status != couponStatusIssued || coupon.UsesCount != 0 {
+		t.Errorf("reversed coupon status = %s uses = %d, want %s uses = 0", coupon.Status, coupon.UsesCount, couponStatusIssued)
+	}
+	if response := ledger.invoke(partner, "reverseredemption", map[string]string{"salesTransactionKey": sale.Key}); response.Status == shim.OK {
+		t.Errorf("fully refunded sales transaction was reversed again")
+	}
+
+	var batch SettlementBatch
+	ledger.mustInvoke(testAdmin, "createsettlementbatch", map[string]string{"partnerKey": partner.PartnerKey}, &batch)
+	if batch.TransactionCount != 2 {
+		t.Errorf("settlement batch count = %d, want 2", batch.TransactionCount)
+	}
+	assertAmount(t, "total sales amount", batch.TotalSalesAmount, "0")
+	assertAmount(t, "total settlement amount", batch.TotalSettlementAmount, "0")
+}
+
+func TestPartialRefundsAddUpToTheSale(t *testing.T) {
+	ledger := newTestLedger(t, "2019-06-15T10:00:00Z")
+	customerKey := "customer:alice"
+	partner := ledger.seedPartnerAndCustomer(customerKey)
+	couponKey := ledger.createCoupon(map[string]string{
+		"name":                "Summer Sale",
+		"expiresOn":           "31-12-2019",
+		"discountAmount":      "10",
+		"revenueSharePercent": "10",
+		"customerKey":         customerKey,
+	})
+	sale := ledger.mustRedeem(partner, couponKey, customerKey, "100").SalesTransaction
+
+	var reversal SalesTransaction
+	ledger.mustInvoke(partner, "reverseredemption", map[string]string{"salesTransactionKey": sale.Key, "refundAmount": "40"}, &reversal)
+	assertAmount(t, "first reversed discount", reversal.DiscountAmount, "-4")
+	assertAmount(t, "first reversed sales amount", reversal.SalesAmount, "-36")
+	assertAmount(t, "first reversed settlement amount", reversal.SettlementAmount, "-32")
+	if coupon := ledger.getCoupon(couponKey); coupon.Status != couponStatusRedeemed {
+		t.Errorf("partly refunded coupon status = %s, want %s", coupon.Status, couponStatusRedeemed)
+	}
+	if response := ledger.invoke(partner, "reverseredemption", map[string]string{"salesTransactionKey": sale.Key, "refundAmount": "60.01"}); response.Status == shim.OK {
+		t.Errorf("refund above the amount left was accepted")
+	}
+
+	ledger.mustInvoke(partner, "reverseredemption", map[string]string{"salesTransactionKey": sale.Key, "refundAmount": "60"}, &reversal)
+	assertAmount(t, "last reversed discount", reversal.DiscountAmount, "-6")
+	assertAmount(t, "last reversed sales amount", reversal.SalesAmount, "-54")
+	assertAmount(t, "last reversed settlement amount", reversal.SettlementAmount, "-48")
+	if coupon := ledger.getCoupon(couponKey); coupon.Status != couponStatusIssued {
+		t.Errorf("fully refunded coupon status = %s, want %s", coupon.Status, couponStatusIssued)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+)
+
+// RichQueryRequest is a CouchDB Mango query restricted to one record type.
+// Selector, Sort and Fields may only reference the whitelisted fields of the
+// record type; the record type itself is enforced through a key range on _id.
+type RichQueryRequest struct {
+	RecordType string                 `json:"recordType"`
+	Selector   map[string]interface{} `json:"selector"`
+	Sort       []interface{}          `json:"sort,omitempty"`
+	Fields     []string               `json:"fields,omitempty"`
+	Limit      int32                  `json:"limit,omitempty"`
+	PageSize   int32                  `json:"pageSize,omitempty"`
+	Bookmark   string                 `json:"bookmark,omitempty"`
+}
+
+const (
+	maxQueryLimit int32 = 1000
+	// Record type of the customer PII held in the private data collection
+	customerPIIRecordType = "customerpii"
+	// Amount sort keys hold the amount in units of 10^-8, the largest currency scale,
+	// zero padded to a fixed width so that they compare like the amounts
+	amountSortKeyScale int32 = 8
+	amountSortKeyDigits      = 20
+)
+
+// Fields that may be used in rich queries per record type. Amounts are stored as
+// strings for exactness, so numeric comparisons use the *SortKey fields and
+// date ranges use the ISO formatted expiryDate.
+var queryableFields = map[string]map[string]bool{
+	couponKeyPrefix: {
+		"key": true, "name": true, "createdDateTime": true, "expiresOn": true, "expiryDate": true,
+		"discountAmount": true, "revenueSharePercent": true, "currency": true, "status": true,
+		"customerKey": true, "timeZone": true, "discountType": true, "discountPercent": true,
+		"maxDiscountAmount": true, "minimumPurchaseAmount": true, "maxUses": true, "usesCount": true,
+		"remainingBalance": true, "transferable": true, "transferredFrom": true, "campaignKey": true,
+		"eligiblePartnerKeys": true, "eligiblePartnerGroups": true, "eligiblePartnerCategories": true,
+	},
+	salesTransactionKeyPrefix: {
+		"key": true, "partnerKey": true, "couponKey": true, "useNumber": true, "assetOriginalPrice": true, "discountAmount": true,
+		"salesAmount": true, "salesAmountSortKey": true, "revenueShareAmount": true,
+		"settlementAmount": true, "settlementAmountSortKey": true, "currency": true, "createdDateTime": true,
+		"settlementBatchKey": true, "reversalOf": true, "refundedAmount": true, "revenueSharePercent": true,
+		"shareBasis": true, "contractKey": true, "contractVersion": true,
+	},
+	customerKeyPrefix: {
+		"key": true, "piiHash": true, "status": true, "version": true,
+		"createdDateTime": true, "updatedDateTime": true,
+	},
+	customerPIIRecordType: {
+		"key": true, "name": true, "email": true,
+	},
+	partnerKeyPrefix: {
+		"key": true, "name": true, "addressKey": true, "group": true, "category": true, "status": true,
+	},
+	addressKeyPrefix: {
+		"key": true, "street": true, "zipCode": true, "state": true, "country": true,
+	},
+	campaignKeyPrefix: {
+		"key": true, "name": true, "ownerMspId": true, "startDate": true, "endDate": true,
+		"currency": true, "totalBudget": true, "maxCoupons": true, "perCustomerLimit": true,
+		"issuedCount": true, "redeemedCount": true,
+	},
+	partnerContractKeyPrefix: {
+		"key": true, "partnerKey": true, "version": true, "effectiveFrom": true, "effectiveTo": true,
+		"shareBasis": true, "revenueSharePercent": true, "createdDateTime": true,
+	},
+	settlementBatchKeyPrefix: {
+		"key": true, "partnerKey": true, "currency": true, "cutOffDateTime": true, "status": true,
+		"transactionCount": true, "createdDateTime": true, "updatedDateTime": true,
+	},
+}
+
+// Amounts stored as decimal strings compare lexically ("9" > "10"), so these fields
+// only take equality conditions and can not be used to sort. Numeric ranges use the
+// salesAmountSortKey and settlementAmountSortKey fields.
+var decimalFields = map[string]bool{
+	"discountAmount": true, "discountPercent": true, "maxDiscountAmount": true, "minimumPurchaseAmount": true,
+	"remainingBalance": true, "revenueSharePercent": true, "assetOriginalPrice": true, "salesAmount": true,
+	"revenueShareAmount": true, "settlementAmount": true, "refundedAmount": true, "totalBudget": true,
+}
+
+var equalityOperators = map[string]bool{
+	"$eq": true, "$ne": true, "$in": true, "$nin": true, "$exists": true,
+}
+
+var queryOperators = map[string]bool{
+	"$eq": true, "$ne": true, "$gt": true, "$gte": true, "$lt": true, "$lte": true,
+	"$in": true, "$nin": true, "$exists": true, "$regex": true, "$and": true, "$or": true,
+	"$nor": true, "$not": true, "$all": true, "$elemMatch": true, "$size": true,
+}
+
+//Function to encode an amount as a fixed width string that sorts like the amount.
+//Negative amounts get a "-" followed by the nines' complement of the digits, so the
+//larger the amount owed the earlier it sorts, and all of them sort before zero.
+func amountSortKey(amount decimal.Decimal) (string, bool) {
+	units := amount.Shift(amountSortKeyScale).Round(0)
+	digits := units.Abs().String()
+	if len(digits) > amountSortKeyDigits {
+		return "", false
+	}
+	digits = strings.Repeat("0", amountSortKeyDigits-len(digits)) + digits
+	if units.Sign() >= 0 {
+		return digits, true
+	}
+	complement := []byte(digits)
+	for i, digit := range complement {
+		complement[i] = '9' - digit + '0'
+	}
+	return "-" + string(complement), true
+}
+
+//Function to set the sort keys of the amounts of a sales transaction
+func setAmountSortKeys(salesTransaction *SalesTransaction) error {
+	var ok bool
+	salesTransaction.SalesAmountSortKey, ok = amountSortKey(salesTransaction.SalesAmount)
+	if !ok {
+		return invalidArgumentError("salesAmount", "Sales amount %s is too large", salesTransaction.SalesAmount.String())
+	}
+	salesTransaction.SettlementAmountSortKey, ok = amountSortKey(salesTransaction.SettlementAmount)
+	if !ok {
+		return invalidArgumentError("settlementAmount", "Settlement amount %s is too large", salesTransaction.SettlementAmount.String())
+	}
+	return nil
+}
+
+//Function to run a rich query against the CouchDB state database
+func (c *CouponChaincode) QueryRecords(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var request RichQueryRequest
+	err := parseArgs("queryrecords", args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if strings.ToLower(request.RecordType) == customerPIIRecordType {
+		return queryCustomerPII(stub, request)
+	}
+	query, err := buildRichQuery(request)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if request.PageSize != 0 || request.Bookmark != "" {
+		err = validatePageSize(request.PageSize)
+		if err != nil {
+			return errorResponse(err)
+		}
+		resultsIterator, metadata, err := stub.GetQueryResultWithPagination(query, request.PageSize, request.Bookmark)
+		if err != nil {
+			return errorResponse(fmt.Errorf("Query failed error : %s", err.Error()))
+		}
+		defer resultsIterator.Close()
+		records, err := generateQueryRecords(resultsIterator)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return shim.Success(newPagedQueryResponse(records, metadata))
+	}
+	resultsIterator, err := stub.GetQueryResult(query)
+	if err != nil {
+		return errorResponse(fmt.Errorf("Query failed error : %s", err.Error()))
+	}
+	defer resultsIterator.Close()
+	records, err := generateQueryRecords(&limitedQueryIterator{StateQueryIteratorInterface: resultsIterator, remaining: getQueryLimit(request)})
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(records)
+}
+
+//Function to validate the request against the whitelist and build the Mango query
+func buildRichQuery(request RichQueryRequest) (string, error) {
+	recordType := strings.ToLower(request.RecordType)
+	allowedFields, ok := queryableFields[recordType]
+	if !ok {
+		return "", invalidArgumentError("recordType", "Invalid Entity Type : %s", request.RecordType)
+	}
+	if request.Selector == nil {
+		return "", invalidArgumentError("selector", "Query selector is required")
+	}
+	err := validateSelector(request.Selector, allowedFields)
+	if err != nil {
+		return "", err
+	}
+	for _, field := range request.Fields {
+		if !allowedFields[field] {
+			return "", invalidArgumentError("fields", "Field %s can not be queried for %s", field, recordType)
+		}
+	}
+	for _, sortField := range request.Sort {
+		err = validateSortField(sortField, allowedFields)
+		if err != nil {
+			return "", err
+		}
+	}
+	if request.Limit < 0 || request.Limit > maxQueryLimit {
+		return "", invalidArgumentError("limit", "Invalid limit %d, expected a value between 1 and %d", request.Limit, maxQueryLimit)
+	}
+	selector := make(map[string]interface{}, len(request.Selector)+1)
+	for field, condition := range request.Selector {
+		selector[field] = condition
+	}
+	keyPrefix := recordType
+	if recordType == customerPIIRecordType {
+		keyPrefix = customerKeyPrefix
+	}
+	startRangeKey, endRangeKey := getRecordTypeRange(keyPrefix)
+	selector["_id"] = map[string]interface{}{"$gte": startRangeKey, "$lt": endRangeKey}
+	query := map[string]interface{}{"selector": selector}
+	if len(request.Sort) > 0 {
+		query["sort"] = request.Sort
+	}
+	if len(request.Fields) > 0 {
+		query["fields"] = request.Fields
+	}
+	if request.PageSize == 0 && request.Bookmark == "" {
+		query["limit"] = getQueryLimit(request)
+	}
+	queryAsBytes, err := json.Marshal(query)
+	if err != nil {
+		return "", err
+	}
+	return string(queryAsBytes), nil
+}
+
+//Function to check that a selector only references whitelisted fields and known operators
+func validateSelector(selector map[string]interface{}, allowedFields map[string]bool) error {
+	for name, condition := range selector {
+		if strings.HasPrefix(name, "$") {
+			if !queryOperators[name] {
+				return invalidArgumentError("selector", "Query operator %s is not supported", name)
+			}
+		} else if !allowedFields[name] {
+			return invalidArgumentError("selector", "Field %s can not be queried", name)
+		} else if decimalFields[name] {
+			err := validateEqualityCondition(name, condition)
+			if err != nil {
+				return err
+			}
+		}
+		err := validateCondition(condition, allowedFields)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateCondition(condition interface{}, allowedFields map[string]bool) error {
+	switch value := condition.(type) {
+	case map[string]interface{}:
+		for name, operand := range value {
+			if strings.HasPrefix(name, "$") {
+				if !queryOperators[name] {
+					return invalidArgumentError("selector", "Query operator %s is not supported", name)
+				}
+				err := validateCondition(operand, allowedFields)
+				if err != nil {
+					return err
+				}
+			} else {
+				err := validateSelector(map[string]interface{}{name: operand}, allowedFields)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		for _, operand := range value {
+			err := validateCondition(operand, allowedFields)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//Function to check that a decimal field is only compared for equality
+func validateEqualityCondition(field string, condition interface{}) error {
+	operators, ok := condition.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	for operator := range operators {
+		if !equalityOperators[operator] {
+			return invalidArgumentError("selector", "Field %s is stored as a decimal string and only supports $eq, $ne, $in, $nin and $exists", field)
+		}
+	}
+	return nil
+}
+
+func validateSortField(sortField interface{}, allowedFields map[string]bool) error {
+	switch value := sortField.(type) {
+	case string:
+		if !allowedFields[value] || decimalFields[value] {
+			return invalidArgumentError("sort", "Field %s can not be used to sort", value)
+		}
+	case map[string]interface{}:
+		for field, direction := range value {
+			if !allowedFields[field] || decimalFields[field] {
+				return invalidArgumentError("sort", "Field %s can not be used to sort", field)
+			}
+			if direction != "asc" && direction != "desc" {
+				return invalidArgumentError("sort", "Invalid sort direction %v for field %s", direction, field)
+			}
+		}
+	default:
+		return invalidArgumentError("sort", "Invalid sort %v", sortField)
+	}
+	return nil
+}
+
+// limitedQueryIterator stops after the requested number of records in case the
+// state database does not honour the limit of the query.
+type limitedQueryIterator struct {
+	shim.StateQueryIteratorInterface
+	remaining int32
+}
+
+func (l *limitedQueryIterator) HasNext() bool {
+	return l.remaining > 0 && l.StateQueryIteratorInterface.HasNext()
+}
+
+func (l *limitedQueryIterator) Next() (*queryresult.KV, error) {
+	l.remaining--
+	return l.StateQueryIteratorInterface.Next()
+}
+
+func getQueryLimit(request RichQueryRequest) int32 {
+	if request.Limit == 0 {
+		return maxQueryLimit
+	}
+	return request.Limit
+}
+
+//Function to query the customer PII collection. Private data queries are not
+//paginated and fail for clients of orgs which are not members of the collection.
+func queryCustomerPII(stub shim.ChaincodeStubInterface, request RichQueryRequest) sc.Response {
+	if request.PageSize != 0 || request.Bookmark != "" {
+		return errorResponse(invalidArgumentError("pageSize", "Pagination is not supported for customer PII queries"))
+	}
+	query, err := buildRichQuery(request)
+	if err != nil {
+		return errorResponse(err)
+	}
+	resultsIterator, err := stub.GetPrivateDataQueryResult(customerPIICollection, query)
+	if err != nil {
+		return errorResponse(fmt.Errorf("Query failed error : %s", err.Error()))
+	}
+	defer resultsIterator.Close()
+	records, err := generateQueryRecords(&limitedQueryIterator{StateQueryIteratorInterface: resultsIterator, remaining: getQueryLimit(request)})
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(records)
+}
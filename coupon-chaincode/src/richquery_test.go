@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestAmountSortKey(t *testing.T) {
+	tests := []struct {
+		amount string
+		want   string
+	}{
+		{"0", "00000000000000000000"},
+		{"12.5", "00000000001250000000"},
+		{"0.00000001", "00000000000000000001"},
+		{"-12.5", "-99999999998749999999"},
+	}
+	for _, tt := range tests {
+		got, ok := amountSortKey(decimal.RequireFromString(tt.amount))
+		if !ok || got != tt.want {
+			t.Errorf("amountSortKey(%s) = %q, %v, want %q", tt.amount, got, ok, tt.want)
+		}
+	}
+	if _, ok := amountSortKey(decimal.RequireFromString("1000000000000")); ok {
+		t.Errorf("amountSortKey accepted an amount wider than the sort key")
+	}
+}
+
+func TestAmountSortKeysSortLikeAmounts(t *testing.T) {
+	amounts := []string{"-100", "-90.5", "-9", "-0.01", "0", "0.01", "9", "10", "90.5", "100"}
+	keys := make([]string, len(amounts))
+	for i, amount := range amounts {
+		keys[i], _ = amountSortKey(decimal.RequireFromString(amount))
+	}
+	if !sort.StringsAreSorted(keys) {
+		t.Errorf("sort keys %v of %v are not sorted", keys, amounts)
+	}
+}
+
+func TestRedemptionAndReversalSetAmountSortKeys(t *testing.T) {
+	ledger := newTestLedger(t, "2019-06-15T10:00:00Z")
+	customerKey := "customer:alice"
+	partner := ledger.seedPartnerAndCustomer(customerKey)
+	couponKey := ledger.createCoupon(map[string]string{
+		"name":                "Summer Sale",
+		"expiresOn":           "31-12-2019",
+		"discountAmount":      "10",
+		"revenueSharePercent": "10",
+		"customerKey":         customerKey,
+	})
+	sale := ledger.mustRedeem(partner, couponKey, customerKey, "100").SalesTransaction
+	if sale.SalesAmountSortKey != "00000000009000000000" || sale.SettlementAmountSortKey != "00000000008000000000" {
+		t.Errorf("sale sort keys = %s, %s", sale.SalesAmountSortKey, sale.SettlementAmountSortKey)
+	}
+
+	var reversal SalesTransaction
+	ledger.mustInvoke(partner, "reverseredemption", map[string]string{"salesTransactionKey": sale.Key}, &reversal)
+	if reversal.SalesAmountSortKey != "-99999999990999999999" || reversal.SettlementAmountSortKey != "-99999999991999999999" {
+		t.Errorf("reversal sort keys = %s, %s", reversal.SalesAmountSortKey, reversal.SettlementAmountSortKey)
+	}
+}
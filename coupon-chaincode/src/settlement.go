@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	settlementBatchKeyPrefix = "settlementbatch"
+	// Index of the sales transactions not yet in a settlement batch. An entry is
+	// removed when its transaction is settled, so it can only be settled once.
+	unsettledSalesTransactionIndex = "unsettled~partner~salestransaction"
+
+	settlementStatusOpen     = "OPEN"
+	settlementStatusApproved = "APPROVED"
+	settlementStatusPaid     = "PAID"
+	settlementStatusDisputed = "DISPUTED"
+)
+
+// Status changes allowed for a settlement batch. A disputed batch is reopened
+// once the dispute is resolved; a paid batch is final.
+var settlementStatusTransitions = map[string][]string{
+	settlementStatusOpen:     {settlementStatusApproved, settlementStatusDisputed},
+	settlementStatusApproved: {settlementStatusPaid, settlementStatusDisputed},
+	settlementStatusDisputed: {settlementStatusOpen},
+}
+
+type SettlementBatch struct {
+	Key                     string          `json:"key"`
+	PartnerKey              string          `json:"partnerKey"`
+	Currency                string          `json:"currency"`
+	CutOffDateTime          string          `json:"cutOffDateTime"`
+	Status                  string          `json:"status"`
+	SalesTransactionKeys    []string        `json:"salesTransactionKeys"`
+	TransactionCount        int             `json:"transactionCount"`
+	TotalSalesAmount        decimal.Decimal `json:"totalSalesAmount"`
+	TotalRevenueShareAmount decimal.Decimal `json:"totalRevenueShareAmount"`
+	TotalSettlementAmount   decimal.Decimal `json:"totalSettlementAmount"`
+	DisputeReason           string          `json:"disputeReason,omitempty"`
+	CreatedDateTime         string          `json:"createdDateTime"`
+	UpdatedDateTime         string          `json:"updatedDateTime,omitempty"`
+}
+
+// SettlementBatchRequest selects the unsettled transactions of the partner in the
+// currency created up to CutOffDateTime (RFC3339), which defaults to now.
+type SettlementBatchRequest struct {
+	PartnerKey     string `json:"partnerKey"`
+	Currency       string `json:"currency,omitempty"`
+	CutOffDateTime string `json:"cutOffDateTime,omitempty"`
+}
+
+type SettlementStatusRequest struct {
+	Key    string `json:"key"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+//Function to settle the unsettled sales transactions of a partner up to a cut-off
+func (c *CouponChaincode) CreateSettlementBatch(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var request SettlementBatchRequest
+	err := parseArgs("createsettlementbatch", args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+	partner, found, err := getPartner(stub, request.PartnerKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !found {
+		return errorResponse(notFoundError(request.PartnerKey, "Partner %s does not exist", request.PartnerKey).withField("partnerKey"))
+	}
+	now, err := c.clock(stub).Now()
+	if err != nil {
+		return errorResponse(err)
+	}
+	cutOff := now
+	if request.CutOffDateTime != "" {
+		cutOff, err = time.Parse(dateTimeFormat, request.CutOffDateTime)
+		if err != nil {
+			return errorResponse(invalidArgumentError("cutOffDateTime", "Invalid cut-off date time : %s", request.CutOffDateTime))
+		}
+		if cutOff.After(now) {
+			return errorResponse(invalidArgumentError("cutOffDateTime", "Cut-off %s is in the future", request.CutOffDateTime))
+		}
+	}
+	settlementBatch := SettlementBatch{
+		Key:                  generateKey(stub, settlementBatchKeyPrefix),
+		PartnerKey:           partner.Key,
+		Currency:             normalizeCurrencyCode(request.Currency),
+		CutOffDateTime:       cutOff.UTC().Format(dateTimeFormat),
+		Status:               settlementStatusOpen,
+		SalesTransactionKeys: make([]string, 0),
+		CreatedDateTime:      now.Format(dateTimeFormat),
+	}
+	salesTransactions, err := getUnsettledSalesTransactions(stub, partner.Key, settlementBatch.Currency, cutOff)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if len(salesTransactions) == 0 {
+		return errorResponse(notFoundError(partner.Key, "Partner %s has no unsettled %s sales transactions up to %s", partner.Key, settlementBatch.Currency, settlementBatch.CutOffDateTime))
+	}
+	for _, salesTransaction := range salesTransactions {
+		salesTransaction.SettlementBatchKey = settlementBatch.Key
+		salesTransactionAsBytes, _ := json.Marshal(salesTransaction)
+		writeErr := stub.PutState(salesTransaction.Key, salesTransactionAsBytes)
+		if writeErr != nil {
+			return errorResponse(fmt.Errorf("SalesTransaction %s PutState failed: %s", salesTransaction.Key, writeErr.Error()))
+		}
+		err = delUnsettledSalesTransactionIndex(stub, salesTransaction)
+		if err != nil {
+			return errorResponse(err)
+		}
+		settlementBatch.SalesTransactionKeys = append(settlementBatch.SalesTransactionKeys, salesTransaction.Key)
+		settlementBatch.TotalSalesAmount = settlementBatch.TotalSalesAmount.Add(salesTransaction.SalesAmount)
+		settlementBatch.TotalRevenueShareAmount = settlementBatch.TotalRevenueShareAmount.Add(salesTransaction.RevenueShareAmount)
+		settlementBatch.TotalSettlementAmount = settlementBatch.TotalSettlementAmount.Add(salesTransaction.SettlementAmount)
+	}
+	settlementBatch.TransactionCount = len(settlementBatch.SalesTransactionKeys)
+	err = putSettlementBatch(stub, settlementBatch)
+	if err != nil {
+		return errorResponse(err)
+	}
+	addEvent(stub, RecordEvent{
+		Type:      eventSettlementBatchCreated,
+		RecordKey: settlementBatch.Key,
+		NewStatus: settlementBatch.Status,
+		Amounts:   settlementBatchAmounts(settlementBatch),
+	})
+	settlementBatchAsBytes, _ := json.Marshal(settlementBatch)
+	return shim.Success(settlementBatchAsBytes)
+}
+
+//Function to move a settlement batch through its lifecycle, a partner may only
+//dispute its own batches
+func (c *CouponChaincode) UpdateSettlementBatchStatus(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var request SettlementStatusRequest
+	err := parseArgs("updatesettlementbatchstatus", args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+	settlementBatch, found, err := getSettlementBatch(stub, request.Key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !found {
+		return errorResponse(notFoundError(strings.ToLower(request.Key), "Settlement batch %s does not exist", strings.ToLower(request.Key)))
+	}
+	status := strings.ToUpper(request.Status)
+	caller := getCaller(stub)
+	if caller.Role == rolePartner && (caller.PartnerKey != settlementBatch.PartnerKey || status != settlementStatusDisputed) {
+		return errorResponse(forbiddenError(settlementBatch.Key, "Access denied : partner %s may not set settlement batch %s to %s", caller.PartnerKey, settlementBatch.Key, status))
+	}
+	if !containsString(settlementStatusTransitions[settlementBatch.Status], status) {
+		return errorResponse(conflictError(settlementBatch.Key, "Settlement batch %s can not change from %s to %s", settlementBatch.Key, settlementBatch.Status, status).withField("status"))
+	}
+	if status == settlementStatusDisputed && strings.TrimSpace(request.Reason) == "" {
+		return errorResponse(invalidArgumentError("reason", "A reason is required to dispute a settlement batch"))
+	}
+	now, err := c.clock(stub).Now()
+	if err != nil {
+		return errorResponse(err)
+	}
+	previousStatus := settlementBatch.Status
+	settlementBatch.Status = status
+	if status == settlementStatusDisputed {
+		settlementBatch.DisputeReason = strings.TrimSpace(request.Reason)
+	}
+	settlementBatch.UpdatedDateTime = now.Format(dateTimeFormat)
+	err = putSettlementBatch(stub, settlementBatch)
+	if err != nil {
+		return errorResponse(err)
+	}
+	addEvent(stub, RecordEvent{
+		Type:      eventSettlementBatchStatusChanged,
+		RecordKey: settlementBatch.Key,
+		OldStatus: previousStatus,
+		NewStatus: settlementBatch.Status,
+	})
+	settlementBatchAsBytes, _ := json.Marshal(settlementBatch)
+	return shim.Success(settlementBatchAsBytes)
+}
+
+//Function to get a settlement batch
+func getSettlementBatch(stub shim.ChaincodeStubInterface, settlementBatchKey string) (SettlementBatch, bool, error) {
+	var settlementBatch SettlementBatch
+	settlementBatchKey = strings.ToLower(settlementBatchKey)
+	resultAsBytes, err := stub.GetState(settlementBatchKey)
+	if err != nil {
+		return settlementBatch, false, fmt.Errorf("Unable to fetch settlement batch %s error : %s", settlementBatchKey, err.Error())
+	}
+	if resultAsBytes == nil {
+		return settlementBatch, false, nil
+	}
+	err = json.Unmarshal(resultAsBytes, &settlementBatch)
+	if err != nil {
+		return settlementBatch, false, fmt.Errorf("Invalid settlement batch record %s error : %s", settlementBatchKey, err.Error())
+	}
+	settlementBatch.Key = settlementBatchKey
+	return settlementBatch, true, nil
+}
+
+func putSettlementBatch(stub shim.ChaincodeStubInterface, settlementBatch SettlementBatch) error {
+	settlementBatchAsBytes, _ := json.Marshal(settlementBatch)
+	err := stub.PutState(settlementBatch.Key, settlementBatchAsBytes)
+	if err != nil {
+		return fmt.Errorf("Settlement batch %s PutState failed: %s", settlementBatch.Key, err.Error())
+	}
+	return nil
+}
+
+//Function to index the unsettled sales transactions written before the settlement
+//index existed
+func (c *CouponChaincode) RebuildSettlementIndex(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	err := parseArgs("rebuildsettlementindex", args, nil)
+	if err != nil {
+		return errorResponse(err)
+	}
+	startRangeKey, endRangeKey := getRecordTypeRange(salesTransactionKeyPrefix)
+	resultsIterator, err := stub.GetStateByRange(startRangeKey, endRangeKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+	indexed := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		var salesTransaction SalesTransaction
+		err = json.Unmarshal(queryResponse.Value, &salesTransaction)
+		if err != nil {
+			return errorResponse(fmt.Errorf("Invalid sales transaction record %s error : %s", queryResponse.Key, err.Error()))
+		}
+		salesTransaction.Key = queryResponse.Key
+		salesTransaction.PartnerKey = strings.ToLower(salesTransaction.PartnerKey)
+		if salesTransaction.PartnerKey == "" || salesTransaction.SettlementBatchKey != "" {
+			continue
+		}
+		err = putUnsettledSalesTransactionIndex(stub, salesTransaction)
+		if err != nil {
+			return errorResponse(err)
+		}
+		indexed++
+	}
+	return shim.Success([]byte(fmt.Sprintf("Indexed %d unsettled sales transactions", indexed)))
+}
+
+//Function to get the unsettled sales transactions of the partner in the currency
+//created up to the cut-off
+func getUnsettledSalesTransactions(stub shim.ChaincodeStubInterface, partnerKey string, currency string, cutOff time.Time) ([]SalesTransaction, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(unsettledSalesTransactionIndex, []string{partnerKey})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	var salesTransactions []SalesTransaction
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		salesTransaction, found, err := getSalesTransaction(stub, attributes[len(attributes)-1])
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		if normalizeCurrencyCode(salesTransaction.Currency) != currency {
+			continue
+		}
+		if salesTransaction.CreatedDateTime != "" {
+			createdDateTime, err := time.Parse(dateTimeFormat, salesTransaction.CreatedDateTime)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid created date time of sales transaction %s : %s", salesTransaction.Key, salesTransaction.CreatedDateTime)
+			}
+			if createdDateTime.After(cutOff) {
+				continue
+			}
+		}
+		salesTransactions = append(salesTransactions, salesTransaction)
+	}
+	return salesTransactions, nil
+}
+
+func putUnsettledSalesTransactionIndex(stub shim.ChaincodeStubInterface, salesTransaction SalesTransaction) error {
+	indexKey, err := stub.CreateCompositeKey(unsettledSalesTransactionIndex, []string{salesTransaction.PartnerKey, salesTransaction.Key})
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(indexKey, indexEntryValue)
+	if err != nil {
+		return fmt.Errorf("Unable to write settlement index for sales transaction %s error : %s", salesTransaction.Key, err.Error())
+	}
+	return nil
+}
+
+func delUnsettledSalesTransactionIndex(stub shim.ChaincodeStubInterface, salesTransaction SalesTransaction) error {
+	indexKey, err := stub.CreateCompositeKey(unsettledSalesTransactionIndex, []string{salesTransaction.PartnerKey, salesTransaction.Key})
+	if err != nil {
+		return err
+	}
+	err = stub.DelState(indexKey)
+	if err != nil {
+		return fmt.Errorf("Unable to delete settlement index for sales transaction %s error : %s", salesTransaction.Key, err.Error())
+	}
+	return nil
+}
+
+//Function to get the totals of a settlement batch carried by events
+func settlementBatchAmounts(settlementBatch SettlementBatch) map[string]decimal.Decimal {
+	return map[string]decimal.Decimal{
+		"totalSalesAmount":        settlementBatch.TotalSalesAmount,
+		"totalRevenueShareAmount": settlementBatch.TotalRevenueShareAmount,
+		"totalSettlementAmount":   settlementBatch.TotalSettlementAmount,
+	}
+}
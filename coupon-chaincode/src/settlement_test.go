@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestSettlementStatusTransitions(t *testing.T) {
+	statuses := []string{settlementStatusOpen, settlementStatusApproved, settlementStatusPaid, settlementStatusDisputed}
+	allowed := map[string]bool{
+		settlementStatusOpen + ">" + settlementStatusApproved:     true,
+		settlementStatusOpen + ">" + settlementStatusDisputed:     true,
+		settlementStatusApproved + ">" + settlementStatusPaid:     true,
+		settlementStatusApproved + ">" + settlementStatusDisputed: true,
+		settlementStatusDisputed + ">" + settlementStatusOpen:     true,
+	}
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := allowed[from+">"+to]
+			got := containsString(settlementStatusTransitions[from], to)
+			if got != want {
+				t.Errorf("transition %s to %s allowed = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+}
+
+func TestSettleRedeemedSales(t *testing.T) {
+	ledger := newTestLedger(t, "2019-06-15T10:00:00Z")
+	customerKey := "customer:alice"
+	partner := ledger.seedPartnerAndCustomer(customerKey)
+	for _, price := range []string{"100", "40"} {
+		couponKey := ledger.createCoupon(map[string]string{
+			"name":                "Summer Sale",
+			"expiresOn":           "31-12-2019",
+			"discountAmount":      "10",
+			"revenueSharePercent": "10",
+			"customerKey":         customerKey,
+		})
+		ledger.mustRedeem(partner, couponKey, customerKey, price)
+	}
+
+	var batch SettlementBatch
+	ledger.mustInvoke(testAdmin, "createsettlementbatch", map[string]string{"partnerKey": partner.PartnerKey}, &batch)
+	if batch.TransactionCount != 2 || batch.Status != settlementStatusOpen {
+		t.Errorf("settlement batch count = %d status = %s, want 2 %s", batch.TransactionCount, batch.Status, settlementStatusOpen)
+	}
+	assertAmount(t, "total sales amount", batch.TotalSalesAmount, "120")
+	assertAmount(t, "total revenue share amount", batch.TotalRevenueShareAmount, "14")
+	assertAmount(t, "total settlement amount", batch.TotalSettlementAmount, "106")
+	for _, salesTransactionKey := range batch.SalesTransactionKeys {
+		var salesTransaction SalesTransaction
+		salesTransactionAsBytes, _ := ledger.stub.GetState(salesTransactionKey)
+		err := json.Unmarshal(salesTransactionAsBytes, &salesTransaction)
+		if err != nil || salesTransaction.SettlementBatchKey != batch.Key {
+			t.Errorf("sales transaction %s settlement batch = %s, want %s", salesTransactionKey, salesTransaction.SettlementBatchKey, batch.Key)
+		}
+	}
+	if response := ledger.invoke(testAdmin, "createsettlementbatch", map[string]string{"partnerKey": partner.PartnerKey}); response.Status == shim.OK {
+		t.Errorf("settled sales transactions were settled again")
+	}
+
+	if response := ledger.invoke(partner, "updatesettlementbatchstatus", map[string]string{"key": batch.Key, "status": settlementStatusApproved}); response.Status == shim.OK {
+		t.Errorf("partner approved its own settlement batch")
+	}
+	for _, status := range []string{settlementStatusApproved, settlementStatusPaid} {
+		ledger.mustInvoke(testAdmin, "updatesettlementbatchstatus", map[string]string{"key": batch.Key, "status": status}, &batch)
+		if batch.Status != status {
+			t.Errorf("settlement batch status = %s, want %s", batch.Status, status)
+		}
+	}
+	if response := ledger.invoke(testAdmin, "updatesettlementbatchstatus", map[string]string{"key": batch.Key, "status": settlementStatusOpen}); response.Status == shim.OK {
+		t.Errorf("paid settlement batch was reopened")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	sc "github.com/hyperledger/fabric/protos/peer"
+)
+
+// TransferCouponRequest moves a transferable coupon to another customer. Every
+// transfer rewrites the coupon with the previous owner in TransferredFrom, so the
+// key history of the coupon is its chain of custody.
+type TransferCouponRequest struct {
+	CouponKey     string `json:"couponKey"`
+	ToCustomerKey string `json:"toCustomerKey"`
+}
+
+//Function to transfer an issued coupon to another customer, the caller must be
+//the current owner or an issuer
+func (c *CouponChaincode) TransferCoupon(stub shim.ChaincodeStubInterface, args []string) sc.Response {
+	var request TransferCouponRequest
+	err := parseArgs("transfercoupon", args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+	coupon, found, err := getCoupon(stub, request.CouponKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !found {
+		return errorResponse(notFoundError(strings.ToLower(request.CouponKey), "Coupon %s does not exist", strings.ToLower(request.CouponKey)))
+	}
+	caller := getCaller(stub)
+	if caller.Role == roleCustomer && caller.CustomerKey != coupon.CustomerKey {
+		return errorResponse(forbiddenError(coupon.Key, "Access denied : coupon %s is not owned by customer %s", coupon.Key, caller.CustomerKey))
+	}
+	if !coupon.Transferable {
+		return errorResponse(forbiddenError(coupon.Key, "Coupon %s is not transferable", coupon.Key))
+	}
+	if coupon.Status != couponStatusIssued {
+		return errorResponse(newChaincodeError(getStatusErrorCode(coupon.Status), coupon.Key, "Only %s coupons can be transferred, coupon %s is %s", couponStatusIssued, coupon.Key, coupon.Status))
+	}
+	now, err := c.clock(stub).Now()
+	if err != nil {
+		return errorResponse(err)
+	}
+	hasExpired, err := hasCouponExpired(coupon.ExpiresOn, coupon.TimeZone, now)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if hasExpired {
+		return errorResponse(newChaincodeError(errCodeExpired, coupon.Key, "Coupon %s has expired", coupon.Key))
+	}
+	toCustomerKey := strings.ToLower(request.ToCustomerKey)
+	if toCustomerKey == coupon.CustomerKey {
+		return errorResponse(invalidArgumentError("toCustomerKey", "Coupon %s is already owned by customer %s", coupon.Key, toCustomerKey).withKey(coupon.Key))
+	}
+	customer, found, err := getCustomer(stub, toCustomerKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !found {
+		return errorResponse(notFoundError(toCustomerKey, "Customer %s does not exist", toCustomerKey).withField("toCustomerKey"))
+	}
+	if customer.Status == customerStatusInactive {
+		return errorResponse(conflictError(customer.Key, "Customer %s is inactive", customer.Key).withField("toCustomerKey"))
+	}
+	transferredCoupon := coupon
+	transferredCoupon.CustomerKey = customer.Key
+	transferredCoupon.TransferredFrom = coupon.CustomerKey
+	transferredCoupon.TransferredDateTime = now.Format(dateTimeFormat)
+	err = transferCampaignCoupon(stub, coupon, transferredCoupon)
+	if err != nil {
+		return errorResponse(err)
+	}
+	couponAsBytes, _ := json.Marshal(transferredCoupon)
+	writeErr := stub.PutState(coupon.Key, couponAsBytes)
+	if writeErr != nil {
+		return errorResponse(fmt.Errorf("Coupon %s PutState failed: %s", coupon.Key, writeErr.Error()))
+	}
+	err = updateCustomerCouponIndex(stub, coupon, transferredCoupon)
+	if err != nil {
+		return errorResponse(err)
+	}
+	addEvent(stub, RecordEvent{
+		Type:      eventCouponTransferred,
+		RecordKey: coupon.Key,
+		OldOwner:  coupon.CustomerKey,
+		NewOwner:  transferredCoupon.CustomerKey,
+	})
+	return shim.Success(couponAsBytes)
+}
+
+//Function to get a coupon
+func getCoupon(stub shim.ChaincodeStubInterface, couponKey string) (Coupon, bool, error) {
+	var coupon Coupon
+	couponKey = strings.ToLower(couponKey)
+	resultAsBytes, err := stub.GetState(couponKey)
+	if err != nil {
+		return coupon, false, fmt.Errorf("Unable to fetch coupon %s error : %s", couponKey, err.Error())
+	}
+	if resultAsBytes == nil {
+		return coupon, false, nil
+	}
+	err = json.Unmarshal(resultAsBytes, &coupon)
+	if err != nil {
+		return coupon, false, fmt.Errorf("Invalid coupon record %s error : %s", couponKey, err.Error())
+	}
+	coupon.Key = couponKey
+	coupon.CustomerKey = strings.ToLower(coupon.CustomerKey)
+	return coupon, true, nil
+}
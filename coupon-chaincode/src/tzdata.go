@@ -0,0 +1,9 @@
+//go:build go1.15
+// +build go1.15
+
+package main
+
+// Embeds the time zone database so issuer time zones load in chaincode containers
+// without zoneinfo, such as the alpine based images. Older toolchains rely on the
+// zoneinfo of the container and reject unknown time zones when coupons are created.
+import _ "time/tzdata"
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// A coupon may be used MaxUses times (once when not set). STORED_VALUE coupons
+// hold DiscountAmount as a balance which is drawn down by every use until it is
+// exhausted; they are only limited to MaxUses uses when it is set. The stored status stays ISSUED while uses remain; callers see the
+// derived PARTIALLY_REDEEMED status once the coupon has been used at least once.
+const (
+	discountTypeStoredValue       = "STORED_VALUE"
+	couponStatusPartiallyRedeemed = "PARTIALLY_REDEEMED"
+)
+
+//Function to get the number of times the coupon may be used
+func getMaxUses(coupon Coupon) int {
+	if coupon.MaxUses <= 0 {
+		return 1
+	}
+	return coupon.MaxUses
+}
+
+//Function to check whether the number of uses of the coupon is limited
+func hasUseLimit(coupon Coupon) bool {
+	return coupon.DiscountType != discountTypeStoredValue || coupon.MaxUses > 0
+}
+
+//Function to check whether the coupon may be used again as far as its use limit goes
+func hasUsesLeft(coupon Coupon) bool {
+	return !hasUseLimit(coupon) || coupon.UsesCount < getMaxUses(coupon)
+}
+
+//Function to get the status reported to callers
+func getDerivedCouponStatus(coupon Coupon) string {
+	if coupon.Status == couponStatusIssued && coupon.UsesCount > 0 {
+		return couponStatusPartiallyRedeemed
+	}
+	return coupon.Status
+}
+
+//Function to initialise the usage fields of a new coupon
+func validateCouponUsage(coupon *Coupon) error {
+	if coupon.MaxUses < 0 {
+		return invalidArgumentError("maxUses", "Max uses must not be negative")
+	}
+	coupon.UsesCount = 0
+	coupon.SalesTransactionKeys = nil
+	if coupon.DiscountType == discountTypeStoredValue {
+		coupon.RemainingBalance = coupon.DiscountAmount
+	} else {
+		coupon.RemainingBalance = decimal.Zero
+	}
+	return nil
+}
+
+//Function to give back a refunded use, a STORED_VALUE coupon gets the refunded
+//discount back on its balance and a use is only returned on a full refund
+func restoreCouponUse(coupon Coupon, refundedDiscount decimal.Decimal, isFullRefund bool) Coupon {
+	if coupon.Status != couponStatusIssued && coupon.Status != couponStatusRedeemed {
+		return coupon
+	}
+	if isFullRefund && coupon.UsesCount > 0 {
+		coupon.UsesCount--
+	}
+	hasBalance := true
+	if coupon.DiscountType == discountTypeStoredValue {
+		coupon.RemainingBalance = decimal.Min(coupon.RemainingBalance.Add(refundedDiscount), coupon.DiscountAmount)
+		hasBalance = coupon.RemainingBalance.IsPositive()
+	}
+	if hasUsesLeft(coupon) && hasBalance {
+		coupon.Status = couponStatusIssued
+	}
+	return coupon
+}
+
+//Function to record a use of the coupon, it is redeemed once no uses or balance remain
+func applyCouponUse(coupon Coupon, salesTransaction SalesTransaction) Coupon {
+	coupon.UsesCount++
+	coupon.SalesTransactionKeys = append(coupon.SalesTransactionKeys, salesTransaction.Key)
+	exhausted := !hasUsesLeft(coupon)
+	if coupon.DiscountType == discountTypeStoredValue {
+		coupon.RemainingBalance = coupon.RemainingBalance.Sub(salesTransaction.DiscountAmount)
+		exhausted = exhausted || !coupon.RemainingBalance.IsPositive()
+	}
+	if exhausted {
+		coupon.Status = couponStatusRedeemed
+	}
+	return coupon
+}
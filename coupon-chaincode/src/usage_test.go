@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestStoredValueCouponIsSpentOverSeveralUses(t *testing.T) {
+	ledger := newTestLedger(t, "2019-06-15T10:00:00Z")
+	customerKey := "customer:alice"
+	partner := ledger.seedPartnerAndCustomer(customerKey)
+	couponKey := ledger.createCoupon(map[string]string{
+		"name":           "Gift Card",
+		"expiresOn":      "31-12-2019",
+		"discountType":   discountTypeStoredValue,
+		"discountAmount": "50",
+		"customerKey":    customerKey,
+	})
+
+	first := ledger.mustRedeem(partner, couponKey, customerKey, "20")
+	assertAmount(t, "first discount", first.SalesTransaction.DiscountAmount, "20")
+	if first.CouponStatus != couponStatusPartiallyRedeemed {
+		t.Errorf("coupon status after first use = %s, want %s", first.CouponStatus, couponStatusPartiallyRedeemed)
+	}
+	coupon := ledger.getCoupon(couponKey)
+	assertAmount(t, "balance after first use", coupon.RemainingBalance, "30")
+
+	second := ledger.mustRedeem(partner, couponKey, customerKey, "40")
+	assertAmount(t, "second discount", second.SalesTransaction.DiscountAmount, "30")
+	if second.CouponStatus != couponStatusRedeemed {
+		t.Errorf("coupon status after balance is spent = %s, want %s", second.CouponStatus, couponStatusRedeemed)
+	}
+	coupon = ledger.getCoupon(couponKey)
+	assertAmount(t, "balance after second use", coupon.RemainingBalance, "0")
+	if coupon.UsesCount != 2 || len(coupon.SalesTransactionKeys) != 2 {
+		t.Errorf("coupon uses = %d sales transactions = %d, want 2 and 2", coupon.UsesCount, len(coupon.SalesTransactionKeys))
+	}
+
+	if response := ledger.redeem(partner, couponKey, customerKey, "10"); response.Status == shim.OK {
+		t.Errorf("spent stored value coupon was redeemed again")
+	}
+}
+
+func TestStoredValueCouponWithMaxUses(t *testing.T) {
+	ledger := newTestLedger(t, "2019-06-15T10:00:00Z")
+	customerKey := "customer:alice"
+	partner := ledger.seedPartnerAndCustomer(customerKey)
+	created := ledger.mustInvoke(testIssuer, "createcoupon", map[string]interface{}{
+		"name":           "Gift Card",
+		"expiresOn":      "31-12-2019",
+		"discountType":   discountTypeStoredValue,
+		"discountAmount": "50",
+		"maxUses":        1,
+		"customerKey":    customerKey,
+	}, nil)
+	couponKey := strings.TrimSuffix(string(created), " created successfully")
+	redeemed := ledger.mustRedeem(partner, couponKey, customerKey, "20")
+	if redeemed.CouponStatus != couponStatusRedeemed {
+		t.Errorf("coupon status after its only use = %s, want %s", redeemed.CouponStatus, couponStatusRedeemed)
+	}
+	assertAmount(t, "balance left", ledger.getCoupon(couponKey).RemainingBalance, "30")
+}